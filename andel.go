@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+// AndelProvider scrapes the prices from the chart on the Andel Energi web page
+type AndelProvider struct {
+	URL string
+}
+
+// NewAndelProvider returns a provider scraping the default Andel Energi price page
+func NewAndelProvider() *AndelProvider {
+	return &AndelProvider{URL: "https://andelenergi.dk/kundeservice/aftaler-og-priser/timepris/"}
+}
+
+// FetchPrices of Andel Energi
+func (a *AndelProvider) FetchPrices(from, to time.Time, area string) ([]PricePoint, error) {
+	var points []PricePoint
+	var fetchErr error
+	c := colly.NewCollector()
+	c.OnResponse(func(r *colly.Response) {
+		all, err := ParseAndelPrices(r.Body, area, time.Now())
+		if err != nil {
+			fetchErr = &PriceError{Kind: ErrPriceParse, Err: err}
+			return
+		}
+		for _, p := range all {
+			if !p.Start.Before(from) && p.Start.Before(to) {
+				points = append(points, p)
+			}
+		}
+	})
+
+	c.OnRequest(func(r *colly.Request) {
+		log.Printf("Visiting %s\n", r.URL)
+	})
+	c.OnError(func(r *colly.Response, e error) {
+		fetchErr = &PriceError{Kind: ErrPriceNetwork, Err: e}
+	})
+
+	if err := c.Visit(a.URL); err != nil && fetchErr == nil {
+		fetchErr = &PriceError{Kind: ErrPriceNetwork, Err: err}
+	}
+	return points, fetchErr
+}
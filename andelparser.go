@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DateAndDay is a date shown below the Andel Energi price chart
+type DateAndDay struct {
+	Date string `json:"date"`
+	Day  string `json:"day"`
+}
+
+// Earea is the price series of one price area in the Andel Energi price chart
+type Earea struct {
+	Labels             []string     `json:"labels"`
+	Values             []string     `json:"values"`
+	ValuesDistribution []string     `json:"valuesDistribution"` //New added for transport expense 20231113, the [tariff] in config.toml is used instead
+	Dates              []DateAndDay `json:"dates"`
+}
+
+// Eall is the data-chart attribute of the Andel Energi price chart
+type Eall struct {
+	East Earea `json:"east"`
+	West Earea `json:"west"`
+}
+
+// Stages of parsing the Andel Energi price chart
+const (
+	AndelStageHTML   = "html"
+	AndelStageJSON   = "json"
+	AndelStageSeries = "series"
+)
+
+var (
+	// ErrAndelChartNotFound is returned when the page has no div#chart-component
+	ErrAndelChartNotFound = errors.New("price chart div#chart-component not found")
+	// ErrAndelNoChartData is returned when the chart has no data-chart attribute
+	ErrAndelNoChartData = errors.New("price chart has no data-chart attribute")
+	// ErrAndelNoPrices is returned when the series of the price area is empty
+	ErrAndelNoPrices = errors.New("price chart has no prices")
+)
+
+// AndelParseError tells at which stage parsing the Andel Energi price chart failed
+type AndelParseError struct {
+	Stage string
+	Area  string
+	Err   error
+}
+
+func (e *AndelParseError) Error() string {
+	if e.Area != "" {
+		return fmt.Sprintf("andel energi %s %s: %v", e.Stage, e.Area, e.Err)
+	}
+	return fmt.Sprintf("andel energi %s: %v", e.Stage, e.Err)
+}
+
+func (e *AndelParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseAndelChart decodes the price chart from an Andel Energi HTML page or from the raw
+// JSON of its data-chart attribute
+func ParseAndelChart(data []byte) (*Eall, error) {
+	chartJSON := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(chartJSON, []byte("{")) {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, &AndelParseError{Stage: AndelStageHTML, Err: err}
+		}
+		chart := doc.Find("div#chart-component").First()
+		if chart.Length() == 0 {
+			return nil, &AndelParseError{Stage: AndelStageHTML, Err: ErrAndelChartNotFound}
+		}
+		attr, ok := chart.Attr("data-chart")
+		if !ok || strings.TrimSpace(attr) == "" {
+			return nil, &AndelParseError{Stage: AndelStageHTML, Err: ErrAndelNoChartData}
+		}
+		chartJSON = []byte(attr)
+	}
+
+	var chart Eall
+	if err := json.Unmarshal(chartJSON, &chart); err != nil {
+		return nil, &AndelParseError{Stage: AndelStageJSON, Err: err}
+	}
+	return &chart, nil
+}
+
+// Area returns the price series of a price area
+func (e *Eall) Area(area string) Earea {
+	if area == PriceAreaWest {
+		return e.West
+	}
+	return e.East
+}
+
+// ParseAndelPrices parses an Andel Energi HTML page or data-chart JSON into the price points of
+// area. The chart only tells the day of month, so now anchors the dates.
+func ParseAndelPrices(data []byte, area string, now time.Time) ([]PricePoint, error) {
+	chart, err := ParseAndelChart(data)
+	if err != nil {
+		return nil, err
+	}
+	points, err := chart.Area(area).pricePoints(now, priceLocation)
+	if err != nil {
+		return nil, &AndelParseError{Stage: AndelStageSeries, Area: area, Err: err}
+	}
+	return points, nil
+}
+
+// Map each value of the series to its time slot in loc.
+//
+// Labels hold the wall clock start ("00", "kl. 13", "13:15") of each value, and the series moves
+// on to the next entry of Dates whenever the hour wraps around midnight. Dates only tell the day
+// of month, so the last date is taken as the one closest to now.
+func (ea Earea) pricePoints(now time.Time, loc *time.Location) ([]PricePoint, error) {
+	if len(ea.Values) == 0 {
+		return nil, ErrAndelNoPrices
+	}
+	if len(ea.Dates) == 0 {
+		return nil, fmt.Errorf("price chart has no dates")
+	}
+	if len(ea.Labels) != len(ea.Values) {
+		return nil, fmt.Errorf("price chart has %d labels for %d values", len(ea.Labels), len(ea.Values))
+	}
+
+	last, err := andelLastDate(ea.Dates[len(ea.Dates)-1].Day, now.In(loc))
+	if err != nil {
+		return nil, err
+	}
+	first := last.AddDate(0, 0, 1-len(ea.Dates))
+
+	points := make([]PricePoint, 0, len(ea.Values))
+	dateIndex := 0
+	prevMinute := -1
+	var prevStart time.Time
+	for i, label := range ea.Labels {
+		hour, minute, err := parseAndelLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		minuteOfDay := hour*60 + minute
+		// A drop of more than an hour is midnight, a smaller one is the repeated hour when DST ends
+		if prevMinute-minuteOfDay > 60 {
+			dateIndex++
+			if dateIndex >= len(ea.Dates) {
+				return nil, fmt.Errorf("price chart label %q at %d is past the last date", label, i)
+			}
+		}
+		prevMinute = minuteOfDay
+
+		day := first.AddDate(0, 0, dateIndex)
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		// The wall clock is ambiguous in the repeated hour when DST ends, take the first
+		// occurrence unless it was already used
+		if earlier := start.Add(-time.Hour); earlier.Hour() == hour && earlier.Minute() == minute {
+			start = earlier
+		}
+		for len(points) > 0 && !start.After(prevStart) {
+			start = start.Add(time.Hour)
+		}
+		prevStart = start
+
+		price, err := strconv.ParseFloat(strings.TrimSpace(ea.Values[i]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("price chart value %q at %d: %w", ea.Values[i], i, err)
+		}
+		points = append(points, PricePoint{Start: start, End: start.Add(time.Hour), Price: price, Currency: "DKK"})
+	}
+
+	// Each slot lasts until the next one starts, the last one as long as the one before it
+	for i := 0; i+1 < len(points); i++ {
+		points[i].End = points[i+1].Start
+	}
+	if n := len(points); n > 1 {
+		points[n-1].End = points[n-1].Start.Add(points[n-2].Duration())
+	}
+	return points, nil
+}
+
+// Return the date closest to now with the given day of month
+func andelLastDate(day string, now time.Time) (time.Time, error) {
+	d, err := strconv.Atoi(strings.TrimSpace(day))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("price chart day %q: %w", day, err)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for offset := 0; offset <= 7; offset++ {
+		for _, date := range []time.Time{today.AddDate(0, 0, offset), today.AddDate(0, 0, -offset)} {
+			if date.Day() == d {
+				return date, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("price chart day %d is not within a week of %s", d, today.Format("2006-01-02"))
+}
+
+// Parse the hour and minute of a chart label like "13", "kl. 13", "13:00" or "13-14"
+func parseAndelLabel(label string) (int, int, error) {
+	s := strings.TrimSpace(label)
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return 0, 0, fmt.Errorf("price chart label %q has no hour", label)
+	}
+	s = s[start:]
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(s)
+	}
+	hour, _ := strconv.Atoi(s[:end])
+	minute := 0
+	if end < len(s) && (s[end] == ':' || s[end] == '.') {
+		rest := s[end+1:]
+		mEnd := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if mEnd < 0 {
+			mEnd = len(rest)
+		}
+		if mEnd > 0 {
+			minute, _ = strconv.Atoi(rest[:mEnd])
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("price chart label %q is not a time of day", label)
+	}
+	return hour, minute, nil
+}
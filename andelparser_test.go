@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Parse a fixture of testdata/andel as if on date at noon
+func parseAndelFixture(t *testing.T, file, area, date string) ([]PricePoint, error) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "andel", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, priceLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ParseAndelPrices(data, area, d.Add(12*time.Hour))
+}
+
+func TestAndelPricePointsDST(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		date  string
+		slots int
+		// starts of the slots from midnight of the day until 04:00
+		starts []string
+	}{
+		{
+			name:  "start",
+			file:  "timepris-2026-03-29-dst-start.html",
+			date:  "2026-03-29",
+			slots: 23,
+			starts: []string{
+				"2026-03-29T00:00:00+01:00",
+				"2026-03-29T01:00:00+01:00",
+				"2026-03-29T03:00:00+02:00",
+			},
+		},
+		{
+			name:  "end",
+			file:  "timepris-2026-10-25-dst-end.html",
+			date:  "2026-10-25",
+			slots: 25,
+			starts: []string{
+				"2026-10-25T00:00:00+02:00",
+				"2026-10-25T01:00:00+02:00",
+				"2026-10-25T02:00:00+02:00",
+				"2026-10-25T02:00:00+01:00",
+				"2026-10-25T03:00:00+01:00",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := parseAndelFixture(t, tt.file, PriceAreaEast, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			day, _ := time.ParseInLocation("2006-01-02", tt.date, priceLocation)
+			next := day.AddDate(0, 0, 1)
+
+			var inDay []PricePoint
+			for _, p := range points {
+				if !p.Start.Before(day) && p.Start.Before(next) {
+					inDay = append(inDay, p)
+				}
+			}
+			if len(inDay) != tt.slots {
+				t.Errorf("got %d slots on %s, want %d", len(inDay), tt.date, tt.slots)
+			}
+			for i, p := range inDay {
+				if p.Duration() != time.Hour {
+					t.Errorf("slot %s lasts %v, want 1h", p.Start.Format(time.RFC3339), p.Duration())
+				}
+				if i > 0 && !p.Start.Equal(inDay[i-1].End) {
+					t.Errorf("slot %s does not start at the end of the one before", p.Start.Format(time.RFC3339))
+				}
+			}
+
+			var got []string
+			for _, p := range inDay {
+				if p.Start.In(priceLocation).Hour() >= 4 {
+					break
+				}
+				got = append(got, p.Start.In(priceLocation).Format(time.RFC3339))
+			}
+			if len(got) != len(tt.starts) {
+				t.Fatalf("slots until 04:00 start at %v, want %v", got, tt.starts)
+			}
+			for i := range got {
+				if got[i] != tt.starts[i] {
+					t.Errorf("slot %d starts at %s, want %s", i, got[i], tt.starts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseAndelFixtures(t *testing.T) {
+	tests := []struct {
+		file       string
+		area       string
+		date       string
+		slots      int
+		first      string
+		firstPrice float64
+		last       string
+		lastPrice  float64
+	}{
+		{"timepris-2026-10-16.html", PriceAreaEast, "2026-10-16", 72, "2026-10-14T00:00:00+02:00", -0.05, "2026-10-16T23:00:00+02:00", 0.33},
+		{"timepris-2026-10-15-tomorrow.html", PriceAreaEast, "2026-10-15", 96, "2026-10-13T00:00:00+02:00", -0.05, "2026-10-16T23:00:00+02:00", 0.41},
+		{"timepris-2026-10-25-dst-end.html", PriceAreaEast, "2026-10-25", 73, "2026-10-23T00:00:00+02:00", -0.05, "2026-10-25T23:00:00+01:00", 0.27},
+		{"timepris-2026-03-29-dst-start.html", PriceAreaEast, "2026-03-29", 71, "2026-03-27T00:00:00+01:00", -0.05, "2026-03-29T23:00:00+02:00", 0.37},
+		{"chart-2026-10-16.json", PriceAreaEast, "2026-10-16", 72, "2026-10-14T00:00:00+02:00", -0.05, "2026-10-16T23:00:00+02:00", 0.33},
+		{"chart-2026-10-16.json", PriceAreaWest, "2026-10-16", 72, "2026-10-14T00:00:00+02:00", -0.12, "2026-10-16T23:00:00+02:00", 0.31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+" "+tt.area, func(t *testing.T) {
+			points, err := parseAndelFixture(t, tt.file, tt.area, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != tt.slots {
+				t.Fatalf("got %d slots, want %d", len(points), tt.slots)
+			}
+			first, last := points[0], points[len(points)-1]
+			if got := first.Start.In(priceLocation).Format(time.RFC3339); got != tt.first || first.Price != tt.firstPrice {
+				t.Errorf("first slot %s %.3f, want %s %.3f", got, first.Price, tt.first, tt.firstPrice)
+			}
+			if got := last.Start.In(priceLocation).Format(time.RFC3339); got != tt.last || last.Price != tt.lastPrice {
+				t.Errorf("last slot %s %.3f, want %s %.3f", got, last.Price, tt.last, tt.lastPrice)
+			}
+			if last.Duration() != time.Hour {
+				t.Errorf("last slot lasts %v, want 1h", last.Duration())
+			}
+		})
+	}
+}
+
+func TestParseAndelNoChart(t *testing.T) {
+	_, err := parseAndelFixture(t, "timepris-no-chart.html", PriceAreaEast, "2026-10-16")
+	if !errors.Is(err, ErrAndelChartNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrAndelChartNotFound)
+	}
+	var parseErr *AndelParseError
+	if !errors.As(err, &parseErr) || parseErr.Stage != AndelStageHTML {
+		t.Errorf("got %#v, want an AndelParseError at stage %s", err, AndelStageHTML)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// Clock is the time the save mode runs on
+type Clock interface {
+	Now() time.Time
+	// Sleep for d of the clock's time
+	Sleep(d time.Duration)
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// clock of the save mode, which is the simulated time when running against the simulator
+var clock Clock = wallClock{}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Run a command given on the command line instead of starting the accessory
+func runCommand(args []string) error {
+	switch args[0] {
+	case "prices":
+		return pricesCommand(args[1:])
+	case "tank":
+		return tankCommand(args[1:])
+	case "modbus":
+		return modbusCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// Print the prices parsed from a saved Andel Energi page or data-chart JSON
+func pricesCommand(args []string) error {
+	fs := flag.NewFlagSet("prices", flag.ExitOnError)
+	area := fs.String("area", PriceAreaEast, "price area, DK1 or DK2")
+	date := fs.String("date", "", "date the page was saved as 2006-01-02 (default today)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: prices [-area DK1|DK2] [-date 2006-01-02] <page.html|chart.json>")
+	}
+
+	now := time.Now()
+	if *date != "" {
+		d, err := time.ParseInLocation("2006-01-02", *date, priceLocation)
+		if err != nil {
+			return err
+		}
+		now = d.Add(12 * time.Hour)
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	points, err := ParseAndelPrices(data, *area, now)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		fmt.Printf("%s %s %.3f %s\n", p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339), p.Price, p.Currency)
+	}
+	return nil
+}
+
+// Print the learned heat-up rates and standby losses of the DHW tank
+func tankCommand(args []string) error {
+	fs := flag.NewFlagSet("tank", flag.ExitOnError)
+	file := fs.String("file", defaultTankFile, "file with the learned tank rates")
+	band := fs.Float64("band", 5, "width of the outdoor temperature bands in C")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*file); err != nil {
+		return err
+	}
+	l, err := OpenTankLearner(*file, *band)
+	if err != nil {
+		return err
+	}
+	fmt.Print(l)
+	return nil
+}
+
+// Serve a simulated Nilan machine over Modbus TCP, for running with NILAN_ADDRESS set to addr
+func modbusCommand(args []string) error {
+	fs := flag.NewFlagSet("modbus", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:5502", "address to listen on")
+	speed := fs.Float64("speed", 1, "how many times as fast as real time the simulator runs")
+	fs.Parse(args)
+
+	s := &ModbusServer{Addr: *addr, Device: NewSimulator(time.Now(), *speed)}
+	fmt.Printf("Serving a simulated Nilan machine on %s\n", *addr)
+	return s.ListenAndServe()
+}
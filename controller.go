@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Controller is the part of nilan.Controller used by the accessory and autoConfigure, so they
+// can run against another device than the Nilan machine
+type Controller interface {
+	FetchReadings() (*nilan.Readings, error)
+	FetchSettings() (*nilan.Settings, error)
+	SendSettings(settings nilan.Settings) error
+}
+
+// ErrIncompleteSettings is returned when a value is missing in the settings read from the machine
+var ErrIncompleteSettings = errors.New("incomplete settings")
+
+// recoveringController returns the panics of Controller as errors. nilan.Controller panics when
+// it can not connect to the machine.
+type recoveringController struct {
+	Controller
+}
+
+func (c recoveringController) FetchReadings() (r *nilan.Readings, err error) {
+	defer recoverError(&err)
+	return c.Controller.FetchReadings()
+}
+
+func (c recoveringController) FetchSettings() (s *nilan.Settings, err error) {
+	defer recoverError(&err)
+	return c.Controller.FetchSettings()
+}
+
+func (c recoveringController) SendSettings(settings nilan.Settings) (err error) {
+	defer recoverError(&err)
+	return c.Controller.SendSettings(settings)
+}
+
+// Set err to the panic if there is one
+func recoverError(err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("nilan controller: %v", p)
+	}
+}
+
+// Return the readings and settings of c, or an error if they can not be read or a setting is missing
+func fetchState(c Controller) (*nilan.Readings, *nilan.Settings, error) {
+	r, err := c.FetchReadings()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading sensors: %w", err)
+	}
+	if r == nil {
+		return nil, nil, errors.New("reading sensors: no readings")
+	}
+	s, err := c.FetchSettings()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading settings: %w", err)
+	}
+	if s == nil {
+		return nil, nil, fmt.Errorf("reading settings: %w", ErrIncompleteSettings)
+	}
+	for _, v := range []struct {
+		name    string
+		missing bool
+	}{
+		{"FanSpeed", s.FanSpeed == nil},
+		{"DesiredRoomTemperature", s.DesiredRoomTemperature == nil},
+		{"DesiredDHWTemperature", s.DesiredDHWTemperature == nil},
+		{"DHWProductionPaused", s.DHWProductionPaused == nil},
+		{"DHWProductionPauseDuration", s.DHWProductionPauseDuration == nil},
+		{"CentralHeatingPaused", s.CentralHeatingPaused == nil},
+		{"CentralHeatingPauseDuration", s.CentralHeatingPauseDuration == nil},
+		{"CentralHeatingIsOn", s.CentralHeatingIsOn == nil},
+		{"VentilationMode", s.VentilationMode == nil},
+		{"VentilationOnPause", s.VentilationOnPause == nil},
+		{"SetpointSupplyTemperature", s.SetpointSupplyTemperature == nil},
+	} {
+		if v.missing {
+			return nil, nil, fmt.Errorf("reading settings: %w: no %s", ErrIncompleteSettings, v.name)
+		}
+	}
+	return r, s, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Circuits of the heat pump guarded against short cycling
+const (
+	CircuitDHW            = "DHW"
+	CircuitCentralHeating = "central heating"
+)
+
+// ErrShortCycle is returned when a circuit may not be switched yet
+var ErrShortCycle = errors.New("short cycle")
+
+// CycleGuard protects the compressor from short cycling. Each circuit stays on at least MinOn,
+// off at least MinOff, and is started at most MaxStarts times an hour, 0 is no limit.
+type CycleGuard struct {
+	MinOn     time.Duration
+	MinOff    time.Duration
+	MaxStarts int
+
+	mu       sync.Mutex
+	circuits map[string]*cycleState
+}
+
+type cycleState struct {
+	on       bool
+	switched time.Time
+	starts   []time.Time
+}
+
+// Observe records that circuit is seen on or off. A change seen here was not made through the
+// guard, like a pause running out on the machine, so it does not start the minimum on or off time
+// and is not counted as a start.
+func (g *CycleGuard) Observe(circuit string, on bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state(circuit).on = on
+}
+
+// Switched records that circuit was switched on or off at now by the save mode or by hand
+func (g *CycleGuard) Switched(circuit string, on bool, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := g.state(circuit)
+	if st.on == on {
+		return
+	}
+	st.on = on
+	st.switched = now
+	if on {
+		st.starts = append(st.starts, now)
+	}
+}
+
+// Return the state of circuit, which starts out off and never switched
+func (g *CycleGuard) state(circuit string) *cycleState {
+	if g.circuits == nil {
+		g.circuits = make(map[string]*cycleState)
+	}
+	st, ok := g.circuits[circuit]
+	if !ok {
+		st = &cycleState{}
+		g.circuits[circuit] = st
+	}
+	return st
+}
+
+// Check returns an ErrShortCycle error if circuit may not be switched on or off at now
+func (g *CycleGuard) Check(circuit string, on bool, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st, ok := g.circuits[circuit]
+	if !ok || st.on == on {
+		return nil
+	}
+	if !on && now.Sub(st.switched) < g.MinOn {
+		return fmt.Errorf("%w: %s has been on since %s, minimum on time is %v", ErrShortCycle, circuit, st.switched.Format("15:04"), g.MinOn)
+	}
+	if on && now.Sub(st.switched) < g.MinOff {
+		return fmt.Errorf("%w: %s has been off since %s, minimum off time is %v", ErrShortCycle, circuit, st.switched.Format("15:04"), g.MinOff)
+	}
+	if on && g.MaxStarts > 0 {
+		recent := st.starts[:0]
+		for _, t := range st.starts {
+			if now.Sub(t) < time.Hour {
+				recent = append(recent, t)
+			}
+		}
+		st.starts = recent
+		if len(recent) >= g.MaxStarts {
+			return fmt.Errorf("%w: %s started %d times in the last hour", ErrShortCycle, circuit, len(recent))
+		}
+	}
+	return nil
+}
+
+// Send s to the machine with the switches of DHW and central heating that the guard refuses
+// left out. The refused switches are logged, and the first is returned unless sending fails.
+func sendGuardedSettings(c Controller, g *CycleGuard, s nilan.Settings) error {
+	now := clock.Now()
+	var refused error
+	if s.DHWProductionPaused != nil {
+		if err := g.Check(CircuitDHW, !*s.DHWProductionPaused, now); err != nil {
+			log.Printf("Not switching: %v", err)
+			refused = err
+			s.DHWProductionPaused = nil
+			s.DHWProductionPauseDuration = nil
+		}
+	}
+	if s.CentralHeatingPaused != nil {
+		if err := g.Check(CircuitCentralHeating, !*s.CentralHeatingPaused, now); err != nil {
+			log.Printf("Not switching: %v", err)
+			if refused == nil {
+				refused = err
+			}
+			s.CentralHeatingPaused = nil
+			s.CentralHeatingPauseDuration = nil
+		}
+	}
+	if s == (nilan.Settings{}) {
+		return refused
+	}
+
+	if err := c.SendSettings(s); err != nil {
+		return err
+	}
+	g.switchedBy(s, now)
+	return refused
+}
+
+// Send s to the machine as set by hand. The guard does not hold it back, but records its
+// switches so that the save mode keeps the minimum on and off times after them.
+func sendManualSettings(c Controller, g *CycleGuard, s nilan.Settings) error {
+	now := clock.Now()
+	if err := c.SendSettings(s); err != nil {
+		return err
+	}
+	g.switchedBy(s, now)
+	return nil
+}
+
+// Record the switches of DHW and central heating sent with s at now
+func (g *CycleGuard) switchedBy(s nilan.Settings, now time.Time) {
+	if s.DHWProductionPaused != nil {
+		g.Switched(CircuitDHW, !*s.DHWProductionPaused, now)
+	}
+	if s.CentralHeatingPaused != nil {
+		g.Switched(CircuitCentralHeating, !*s.CentralHeatingPaused, now)
+	}
+}
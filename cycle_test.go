@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Controller recording the settings sent to it
+type sentSettings []nilan.Settings
+
+func (c *sentSettings) FetchReadings() (*nilan.Readings, error) { return &nilan.Readings{}, nil }
+func (c *sentSettings) FetchSettings() (*nilan.Settings, error) { return &nilan.Settings{}, nil }
+func (c *sentSettings) SendSettings(s nilan.Settings) error {
+	*c = append(*c, s)
+	return nil
+}
+
+func TestCycleGuardPauseRunsOut(t *testing.T) {
+	g := &CycleGuard{MinOn: 15 * time.Minute, MinOff: 15 * time.Minute, MaxStarts: 1}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation)
+
+	g.Observe(CircuitDHW, true)
+	if err := g.Check(CircuitDHW, false, start); err != nil {
+		t.Fatalf("pausing: %v", err)
+	}
+	g.Switched(CircuitDHW, false, start)
+
+	// The machine's own pause runs out after three hours, which is not a start of the guard
+	expired := start.Add(3 * time.Hour)
+	g.Observe(CircuitDHW, true)
+	if err := g.Check(CircuitDHW, false, expired.Add(time.Minute)); err != nil {
+		t.Errorf("pausing again after the pause ran out: %v", err)
+	}
+	g.Switched(CircuitDHW, false, expired.Add(time.Minute))
+
+	// The minimum off time holds after the guard's own switch, but no start was used up
+	if err := g.Check(CircuitDHW, true, expired.Add(10*time.Minute)); !errors.Is(err, ErrShortCycle) {
+		t.Errorf("starting within the minimum off time: got %v, want %v", err, ErrShortCycle)
+	}
+	if err := g.Check(CircuitDHW, true, expired.Add(20*time.Minute)); err != nil {
+		t.Errorf("starting after the minimum off time: %v", err)
+	}
+}
+
+func TestSendManualSettings(t *testing.T) {
+	g := &CycleGuard{MinOn: 15 * time.Minute, MinOff: 15 * time.Minute}
+	var c sentSettings
+	on, off := false, true
+
+	// Switched on by the save mode and right after off by hand, which the guard does not refuse
+	if err := sendGuardedSettings(&c, g, nilan.Settings{DHWProductionPaused: &on}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendGuardedSettings(&c, g, nilan.Settings{DHWProductionPaused: &off}); !errors.Is(err, ErrShortCycle) {
+		t.Errorf("save mode switching off right after on: got %v, want %v", err, ErrShortCycle)
+	}
+	if err := sendManualSettings(&c, g, nilan.Settings{DHWProductionPaused: &off}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 2 || *c[1].DHWProductionPaused != off {
+		t.Fatalf("sent %d settings, want the switch on and the manual switch off", len(c))
+	}
+
+	// The save mode keeps the minimum off time after the manual switch
+	if err := sendGuardedSettings(&c, g, nilan.Settings{DHWProductionPaused: &on}); !errors.Is(err, ErrShortCycle) {
+		t.Errorf("save mode switching on right after a manual off: got %v, want %v", err, ErrShortCycle)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/theherk/viper"
+)
+
+// Deadline is a time of day when the DHW tank must be hot
+type Deadline struct {
+	// Time of day as "15:04"
+	Time string `mapstructure:"time"`
+	// Temperature in C the tank top must have at Time
+	Temperature float64 `mapstructure:"temperature"`
+}
+
+// Read the [[deadlines]] of config.toml
+func loadDeadlines() ([]Deadline, error) {
+	var deadlines []Deadline
+	if err := viper.UnmarshalKey("deadlines", &deadlines); err != nil {
+		return nil, err
+	}
+	for _, d := range deadlines {
+		if _, err := time.Parse("15:04", d.Time); err != nil {
+			return nil, fmt.Errorf("deadline time %q: %w", d.Time, err)
+		}
+	}
+	return deadlines, nil
+}
+
+// Next returns the first time the deadline is due after now
+func (d Deadline) Next(now time.Time) time.Time {
+	clock, _ := time.Parse("15:04", d.Time)
+	now = now.In(priceLocation)
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, priceLocation)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Return the heating slots with slots added so that the tank, at tankTemp now, reaches the
+// temperature of every deadline before until. For each deadline in turn the cheapest slots
+// before it are added until the tank model says it is hot enough.
+func deadlineSlots(chosen, points []PricePoint, now time.Time, tankTemp float64, model TankModel, deadlines []Deadline, until time.Time) []PricePoint {
+	if model.HeatRate <= 0 || model.HeatRate+model.StandbyLoss <= 0 {
+		log.Printf("error in config: %v, not heating for the deadlines", ErrNoHeatRate)
+		return chosen
+	}
+	type due struct {
+		at   time.Time
+		temp float64
+	}
+	var dues []due
+	for _, d := range deadlines {
+		if at := d.Next(now); at.Before(until) {
+			dues = append(dues, due{at, d.Temperature})
+		}
+	}
+	sort.Slice(dues, func(i, j int) bool {
+		return dues[i].at.Before(dues[j].at)
+	})
+
+	taken := make(map[int64]bool)
+	for _, p := range chosen {
+		taken[p.Start.UnixNano()] = true
+	}
+	slots := append([]PricePoint(nil), chosen...)
+	for _, d := range dues {
+		// The tank loses heat all the time and gains HeatRate+StandbyLoss for every hour heating
+		heating := heatingBetween(slots, now, d.at)
+		missing := d.temp - (tankTemp - model.StandbyLoss*d.at.Sub(now).Hours() + (model.HeatRate+model.StandbyLoss)*heating.Hours())
+		if missing <= 0 {
+			continue
+		}
+		need := time.Duration(math.Ceil(missing / (model.HeatRate + model.StandbyLoss) * float64(time.Hour)))
+
+		var candidates []PricePoint
+		for _, p := range points {
+			if p.End.After(now) && p.Start.Before(d.at) && !taken[p.Start.UnixNano()] {
+				candidates = append(candidates, p)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Price < candidates[j].Price
+		})
+		added := time.Duration(0)
+		for _, p := range candidates {
+			if added >= need {
+				break
+			}
+			slots = append(slots, p)
+			taken[p.Start.UnixNano()] = true
+			added += heatingBetween([]PricePoint{p}, now, d.at)
+		}
+		if added < need {
+			log.Printf("The hot water can not reach %.1f C at %s, %v of heating is missing", d.temp, d.at.Format("15:04"), need-added)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Start.Before(slots[j].Start)
+	})
+	return slots
+}
+
+// Return how much of the slots lies between from and to
+func heatingBetween(slots []PricePoint, from, to time.Time) time.Duration {
+	var d time.Duration
+	for _, p := range slots {
+		start, end := p.Start, p.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			d += end.Sub(start)
+		}
+	}
+	return d
+}
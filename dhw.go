@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DHWState is the state of the DHW controller
+type DHWState int
+
+// States of the DHW controller
+const (
+	// DHWIdle waits for a cheap slot with DHW production paused
+	DHWIdle DHWState = iota
+	// DHWScheduledHeating heats in a cheap slot, and after it until the tank is near its target
+	DHWScheduledHeating
+	// DHWEmergencyHeating heats outside the cheap slots as the tank is too cold
+	DHWEmergencyHeating
+	// DHWBlocked holds DHW production on or off until the minimum on or off time has passed
+	DHWBlocked
+	// DHWManualOverride leaves DHW production alone, as save mode is off or it was set by hand
+	DHWManualOverride
+)
+
+func (s DHWState) String() string {
+	switch s {
+	case DHWIdle:
+		return "Idle"
+	case DHWScheduledHeating:
+		return "ScheduledHeating"
+	case DHWEmergencyHeating:
+		return "EmergencyHeating"
+	case DHWBlocked:
+		return "Blocked"
+	case DHWManualOverride:
+		return "ManualOverride"
+	}
+	return fmt.Sprintf("DHWState(%d)", int(s))
+}
+
+// DHWInput is what the DHW controller decides on
+type DHWInput struct {
+	Now time.Time
+	// SaveMode is set when the power save mode controls DHW production
+	SaveMode bool
+	// Scheduled is set in a cheap heating slot
+	Scheduled bool
+	// Deficit is how many C the tank top is below the desired DHW temperature
+	Deficit float64
+	// MustHeat is the deficit to heat at outside the cheap slots
+	MustHeat float64
+	// StopHeat is the deficit to stop heating at outside the cheap slots
+	StopHeat float64
+	// ProductionOn is set when DHW production is not paused on the machine
+	ProductionOn bool
+}
+
+// DHWController decides when DHW production is on. Production is only switched when Guard
+// allows it, and after a manual change it is left alone for Override.
+type DHWController struct {
+	Guard    *CycleGuard
+	Override time.Duration
+
+	mu            sync.Mutex
+	state         DHWState
+	overrideUntil time.Time
+}
+
+// ManualOverride leaves DHW production alone for Override from now
+func (c *DHWController) ManualOverride(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrideUntil = now.Add(c.Override)
+}
+
+// State returns the current state
+func (c *DHWController) State() DHWState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Step moves the controller on with in and returns whether DHW production should be on
+func (c *DHWController) Step(in DHWInput) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The machine may have been switched by someone else or its pause may have run out
+	c.Guard.Observe(CircuitDHW, in.ProductionOn)
+
+	next, reason := c.wanted(in)
+	on := next == DHWScheduledHeating || next == DHWEmergencyHeating
+	if next == DHWManualOverride {
+		on = in.ProductionOn
+	}
+	if on != in.ProductionOn && next != DHWManualOverride {
+		if err := c.Guard.Check(CircuitDHW, on, in.Now); err != nil {
+			next, reason = DHWBlocked, fmt.Sprintf("%s held back: %v", next, err)
+			on = in.ProductionOn
+		}
+	}
+
+	if next != c.state {
+		log.Printf("DHW %s -> %s: %s", c.state, next, reason)
+		c.state = next
+	}
+	return on
+}
+
+// Return the state in calls for and why
+func (c *DHWController) wanted(in DHWInput) (DHWState, string) {
+	heating := in.ProductionOn && (c.state == DHWScheduledHeating || c.state == DHWEmergencyHeating || c.state == DHWBlocked)
+	switch {
+	case !in.SaveMode:
+		return DHWManualOverride, "save mode is off"
+	case in.Now.Before(c.overrideUntil):
+		return DHWManualOverride, "set by hand until " + c.overrideUntil.Format("15:04")
+	case in.Scheduled:
+		return DHWScheduledHeating, "in a cheap heating slot"
+	case in.Deficit >= in.MustHeat:
+		return DHWEmergencyHeating, fmt.Sprintf("tank is %.1f C below target", in.Deficit)
+	case heating && in.Deficit >= in.StopHeat:
+		if c.state == DHWEmergencyHeating {
+			return DHWEmergencyHeating, fmt.Sprintf("tank is still %.1f C below target", in.Deficit)
+		}
+		return DHWScheduledHeating, fmt.Sprintf("slot is over but tank is still %.1f C below target", in.Deficit)
+	}
+	return DHWIdle, fmt.Sprintf("no cheap slot and tank is %.1f C below target", in.Deficit)
+}
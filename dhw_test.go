@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDHWControllerStep(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation)
+	type step struct {
+		minute    int
+		saveMode  bool
+		scheduled bool
+		deficit   float64
+		state     DHWState
+		on        bool
+	}
+	tests := []struct {
+		name string
+		// on is whether DHW production is on at the start
+		on bool
+		// override is set for a manual override at the start
+		override bool
+		steps    []step
+	}{
+		{
+			name: "idle to scheduled",
+			steps: []step{
+				{minute: 0, saveMode: true, deficit: 5, state: DHWIdle},
+				{minute: 20, saveMode: true, scheduled: true, deficit: 5, state: DHWScheduledHeating, on: true},
+			},
+		},
+		{
+			name: "scheduled to idle at stop heat",
+			steps: []step{
+				{minute: 0, saveMode: true, scheduled: true, deficit: 5, state: DHWScheduledHeating, on: true},
+				{minute: 30, saveMode: true, deficit: 3, state: DHWScheduledHeating, on: true},
+				{minute: 40, saveMode: true, deficit: 1.9, state: DHWIdle},
+			},
+		},
+		{
+			name: "emergency at must heat",
+			steps: []step{
+				{minute: 0, saveMode: true, deficit: 9, state: DHWIdle},
+				{minute: 20, saveMode: true, deficit: 10, state: DHWEmergencyHeating, on: true},
+				{minute: 50, saveMode: true, deficit: 5, state: DHWEmergencyHeating, on: true},
+				{minute: 60, saveMode: true, deficit: 1, state: DHWIdle},
+			},
+		},
+		{
+			name: "blocked by the guard",
+			steps: []step{
+				{minute: 0, saveMode: true, scheduled: true, deficit: 5, state: DHWScheduledHeating, on: true},
+				{minute: 5, saveMode: true, deficit: 0, state: DHWBlocked, on: true},
+				{minute: 14, saveMode: true, deficit: 0, state: DHWBlocked, on: true},
+				{minute: 15, saveMode: true, deficit: 0, state: DHWIdle},
+				{minute: 20, saveMode: true, scheduled: true, deficit: 0, state: DHWBlocked},
+				{minute: 30, saveMode: true, scheduled: true, deficit: 0, state: DHWScheduledHeating, on: true},
+			},
+		},
+		{
+			name:     "manual override expires",
+			on:       true,
+			override: true,
+			steps: []step{
+				{minute: 0, saveMode: true, state: DHWManualOverride, on: true},
+				{minute: 59, saveMode: true, state: DHWManualOverride, on: true},
+				{minute: 60, saveMode: true, state: DHWIdle},
+			},
+		},
+		{
+			name: "save mode off",
+			on:   true,
+			steps: []step{
+				{minute: 0, saveMode: false, state: DHWManualOverride, on: true},
+				{minute: 30, saveMode: true, state: DHWIdle},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := &CycleGuard{MinOn: 15 * time.Minute, MinOff: 15 * time.Minute, MaxStarts: 3}
+			c := &DHWController{Guard: guard, Override: time.Hour}
+			if tt.override {
+				c.ManualOverride(start)
+			}
+			on := tt.on
+			for _, s := range tt.steps {
+				now := start.Add(time.Duration(s.minute) * time.Minute)
+				got := c.Step(DHWInput{
+					Now:          now,
+					SaveMode:     s.saveMode,
+					Scheduled:    s.scheduled,
+					Deficit:      s.deficit,
+					MustHeat:     10,
+					StopHeat:     2,
+					ProductionOn: on,
+				})
+				if got != on {
+					// Switched the way sendGuardedSettings does
+					guard.Switched(CircuitDHW, got, now)
+					on = got
+				}
+				if c.State() != s.state || got != s.on {
+					t.Fatalf("minute %d: got %s on %t, want %s on %t", s.minute, c.State(), got, s.state, s.on)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Datasets of the Energi Data Service with day-ahead spot prices
+const (
+	DatasetElspotprices   = "Elspotprices"
+	DatasetDayAheadPrices = "DayAheadPrices"
+)
+
+// EnergiDataServiceProvider fetches spot prices from the Energinet Energi Data Service API
+type EnergiDataServiceProvider struct {
+	// BaseURL of the API, e.g. "https://api.energidataservice.dk"
+	BaseURL string
+	// Dataset is either DatasetDayAheadPrices or DatasetElspotprices, which is no longer updated
+	// since day-ahead moved to 15-minute MTU
+	Dataset string
+	Client  *http.Client
+}
+
+// NewEnergiDataServiceProvider returns a provider using the public Energi Data Service API
+func NewEnergiDataServiceProvider(dataset string) *EnergiDataServiceProvider {
+	if dataset == "" {
+		dataset = DatasetDayAheadPrices
+	}
+	return &EnergiDataServiceProvider{
+		BaseURL: "https://api.energidataservice.dk",
+		Dataset: dataset,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// edsRecord holds the fields used from both the Elspotprices and DayAheadPrices datasets
+type edsRecord struct {
+	HourUTC          string   `json:"HourUTC"`
+	TimeUTC          string   `json:"TimeUTC"`
+	PriceArea        string   `json:"PriceArea"`
+	SpotPriceDKK     *float64 `json:"SpotPriceDKK"`
+	DayAheadPriceDKK *float64 `json:"DayAheadPriceDKK"`
+}
+
+type edsResponse struct {
+	Dataset string      `json:"dataset"`
+	Records []edsRecord `json:"records"`
+}
+
+// FetchPrices of Energi Data Service in DKK/kWh
+func (e *EnergiDataServiceProvider) FetchPrices(from, to time.Time, area string) ([]PricePoint, error) {
+	// The API interprets start and end in Danish time, so ask for whole days around the range
+	q := url.Values{}
+	q.Set("start", from.AddDate(0, 0, -1).Format("2006-01-02"))
+	q.Set("end", to.AddDate(0, 0, 1).Format("2006-01-02"))
+	q.Set("filter", fmt.Sprintf(`{"PriceArea":["%s"]}`, area))
+	q.Set("limit", "0")
+	u := e.BaseURL + "/dataset/" + e.Dataset + "?" + q.Encode()
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	log.Printf("Fetching prices from %s\n", u)
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, &PriceError{Kind: ErrPriceNetwork, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &PriceError{Kind: ErrPriceNetwork, Err: fmt.Errorf("energi data service returned %s", resp.Status)}
+	}
+
+	var body edsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, &PriceError{Kind: ErrPriceParse, Err: fmt.Errorf("decoding energi data service response: %w", err)}
+	}
+
+	var points []PricePoint
+	for _, r := range body.Records {
+		if r.PriceArea != "" && r.PriceArea != area {
+			continue
+		}
+		ts, price := r.HourUTC, r.SpotPriceDKK
+		if ts == "" {
+			ts, price = r.TimeUTC, r.DayAheadPriceDKK
+		}
+		if price == nil {
+			continue
+		}
+		start, err := time.ParseInLocation("2006-01-02T15:04:05", ts, time.UTC)
+		if err != nil {
+			return nil, &PriceError{Kind: ErrPriceParse, Err: fmt.Errorf("parsing energi data service time %q: %w", ts, err)}
+		}
+		start = start.In(from.Location())
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		points = append(points, PricePoint{Start: start, End: start.Add(e.slotLength()), Price: *price / 1000, Currency: "DKK"}) // DKK/MWh to DKK/kWh
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Start.Before(points[j].Start)
+	})
+	return points, nil
+}
+
+// Length of the slots in the dataset, DayAheadPrices has quarter hours since the 15-minute MTU
+func (e *EnergiDataServiceProvider) slotLength() time.Duration {
+	if e.Dataset == DatasetDayAheadPrices {
+		return 15 * time.Minute
+	}
+	return time.Hour
+}
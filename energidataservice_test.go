@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnergiDataServiceFetchPrices(t *testing.T) {
+	tests := []struct {
+		file       string
+		dataset    string
+		area       string
+		day        string
+		slots      int
+		slot       time.Duration
+		firstPrice float64
+		lastPrice  float64
+	}{
+		{"dayaheadprices-2026-10-16-DK2.json", DatasetDayAheadPrices, PriceAreaEast, "2026-10-16", 96, 15 * time.Minute, 0.290, 0.27071959},
+		{"elspotprices-2025-09-30-DK1.json", DatasetElspotprices, PriceAreaWest, "2025-09-30", 24, time.Hour, 0.280, 0.321265266},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "energidataservice", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/dataset/"+tt.dataset {
+					t.Errorf("path = %s, want /dataset/%s", r.URL.Path, tt.dataset)
+				}
+				if got, want := r.URL.Query().Get("filter"), `{"PriceArea":["`+tt.area+`"]}`; got != want {
+					t.Errorf("filter = %s, want %s", got, want)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			p := NewEnergiDataServiceProvider(tt.dataset)
+			p.BaseURL = server.URL
+			from, err := time.ParseInLocation("2006-01-02", tt.day, priceLocation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			to := from.AddDate(0, 0, 1)
+			points, err := p.FetchPrices(from, to, tt.area)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(points) != tt.slots {
+				t.Fatalf("got %d slots, want %d", len(points), tt.slots)
+			}
+			if !coversRange(points, from, to) {
+				t.Errorf("slots do not cover %s", tt.day)
+			}
+			for i, p := range points {
+				if p.Duration() != tt.slot {
+					t.Errorf("slot %d %s lasts %v, want %v", i, p.Start, p.Duration(), tt.slot)
+				}
+				if i > 0 && !p.Start.Equal(points[i-1].End) {
+					t.Errorf("slot %d starts %s, want %s", i, p.Start, points[i-1].End)
+				}
+			}
+			first, last := points[0], points[len(points)-1]
+			if !first.Start.Equal(from) || math.Abs(first.Price-tt.firstPrice) > 1e-9 {
+				t.Errorf("first slot %s %.6f, want %s %.6f", first.Start, first.Price, from, tt.firstPrice)
+			}
+			if !last.End.Equal(to) || math.Abs(last.Price-tt.lastPrice) > 1e-9 {
+				t.Errorf("last slot ends %s %.6f, want %s %.6f", last.End, last.Price, to, tt.lastPrice)
+			}
+		})
+	}
+}
+
+func TestEnergiDataServiceDefaultDataset(t *testing.T) {
+	if got := NewEnergiDataServiceProvider("").Dataset; got != DatasetDayAheadPrices {
+		t.Errorf("default dataset = %s, want %s", got, DatasetDayAheadPrices)
+	}
+}
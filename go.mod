@@ -5,7 +5,6 @@ go 1.19
 require github.com/brutella/hc v1.2.5
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.0 // indirect
 	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/antchfx/htmlquery v1.2.5 // indirect
 	github.com/antchfx/xmlquery v1.3.12 // indirect
@@ -37,6 +36,7 @@ require (
 
 require (
 	github.com/brutella/dnssd v1.2.1 // indirect
+	github.com/PuerkitoBio/goquery v1.8.0
 	github.com/gocolly/colly v1.2.0
 	github.com/miekg/dns v1.1.50 // indirect
 	github.com/pjuzeliunas/nilan v0.0.0-20220217201618-aa1220fc290e
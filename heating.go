@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Setpoints the central heating controller pre-heats with
+const (
+	HeatingSetpointSupply = "supply"
+	HeatingSetpointRoom   = "room"
+)
+
+// HeatingInput is what the central heating controller decides on
+type HeatingInput struct {
+	Now time.Time
+	// Expensive is set in one of the most expensive slots
+	Expensive bool
+	// Preheat is set shortly before the most expensive slots
+	Preheat bool
+	// Room temperature in C
+	Room float64
+	// Setpoint of the machine in C times 10, either the supply or the room setpoint
+	Setpoint int
+	// Paused is set when central heating is paused on the machine
+	Paused bool
+}
+
+// HeatingController pauses the central heating in the most expensive slots and raises the
+// setpoint by Boost C before them. The room is kept within [ComfortMin, ComfortMax]: heating
+// is never paused below ComfortMin and not boosted above ComfortMax. After a manual change the
+// pause is left alone for Override. The setpoint from before a pre-heat and the pause are kept
+// in Path if set, so a restart does not take the raised setpoint as the one set by hand.
+type HeatingController struct {
+	ComfortMin float64
+	ComfortMax float64
+	Boost      float64
+	Override   time.Duration
+	Path       string
+
+	mu            sync.Mutex
+	base          int
+	boosted       bool
+	paused        bool
+	overrideUntil time.Time
+	saved         heatingState
+}
+
+// heatingState is the part of the HeatingController kept in its Path
+type heatingState struct {
+	Base    int  `json:"base"`
+	Boosted bool `json:"boosted"`
+	Paused  bool `json:"paused"`
+}
+
+// OpenHeatingController reads the state of the controller from path. An empty path or a missing
+// file starts without a pre-heat or pause.
+func OpenHeatingController(path string) (*HeatingController, error) {
+	c := &HeatingController{Path: path}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.saved); err != nil {
+		return c, err
+	}
+	c.base, c.boosted, c.paused = c.saved.Base, c.saved.Boosted, c.saved.Paused
+	return c, nil
+}
+
+// ManualOverride leaves the pause of central heating alone for Override from now
+func (c *HeatingController) ManualOverride(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrideUntil = now.Add(c.Override)
+}
+
+// Step returns whether central heating should be paused and the setpoint to use
+func (c *HeatingController) Step(in HeatingInput) (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.save()
+
+	if in.Now.Before(c.overrideUntil) {
+		// Set by hand, which also ends a pre-heat
+		c.paused = false
+		return in.Paused, c.unboost(in, "set by hand until "+c.overrideUntil.Format("15:04"))
+	}
+
+	// The setpoint is the one set by hand unless it is ours
+	if !c.boosted || in.Setpoint != c.boostedSetpoint() {
+		c.base = in.Setpoint
+	}
+
+	paused, reason := false, ""
+	switch {
+	case in.Expensive && in.Room < c.ComfortMin:
+		reason = fmt.Sprintf("room is %.1f C, below the comfort band", in.Room)
+	case in.Expensive:
+		paused, reason = true, "in an expensive slot"
+	}
+	boost := in.Preheat && !paused && in.Room < c.ComfortMax
+
+	if paused != in.Paused {
+		if reason == "" {
+			reason = "expensive slot is over"
+		}
+		log.Printf("Central heating paused: %v, %s", paused, reason)
+	}
+	c.paused = paused
+	if boost != c.boosted {
+		log.Printf("Central heating pre-heat: %v, room is %.1f C", boost, in.Room)
+		c.boosted = boost
+	}
+	if boost {
+		return paused, c.boostedSetpoint()
+	}
+	return paused, c.base
+}
+
+// Release hands central heating back when the controller is turned off. It returns whether
+// central heating should be paused and the setpoint to use, which end a pause and a pre-heat of
+// the controller until the machine has them.
+func (c *HeatingController) Release(in HeatingInput) (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.save()
+
+	paused := in.Paused
+	if c.paused && in.Paused {
+		log.Printf("Central heating paused: false, heating optimizer is off")
+		paused = false
+	} else {
+		c.paused = false
+	}
+	return paused, c.unboost(in, "heating optimizer is off")
+}
+
+// Write the state to Path when it changed
+func (c *HeatingController) save() {
+	state := heatingState{Base: c.base, Boosted: c.boosted, Paused: c.paused}
+	if c.Path == "" || state == c.saved {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.WriteFile(c.Path, data, 0666)
+	}
+	if err != nil {
+		log.Printf("error saving the heating state: %v", err)
+		return
+	}
+	c.saved = state
+}
+
+// Return the setpoint to end a pre-heat with, which is the one of in once the machine has the
+// setpoint from before the pre-heat or it was changed by hand
+func (c *HeatingController) unboost(in HeatingInput, reason string) int {
+	if !c.boosted {
+		return in.Setpoint
+	}
+	if in.Setpoint != c.boostedSetpoint() {
+		c.boosted = false
+		return in.Setpoint
+	}
+	log.Printf("Central heating pre-heat: false, %s", reason)
+	return c.base
+}
+
+// Setpoint while pre-heating
+func (c *HeatingController) boostedSetpoint() int {
+	return c.base + int(c.Boost*10)
+}
+
+// Return the most expensive slots of each day of points covering d
+func expensiveSlots(points []PricePoint, d time.Duration) []PricePoint {
+	days := make(map[time.Time][]PricePoint)
+	var order []time.Time
+	for _, p := range points {
+		day := planningWindowStart(p.Start)
+		if _, ok := days[day]; !ok {
+			order = append(order, day)
+		}
+		days[day] = append(days[day], p)
+	}
+	var expensive []PricePoint
+	for _, day := range order {
+		expensive = append(expensive, highestPricePoints(days[day], d)...)
+	}
+	return expensive
+}
+
+// Tells if one of the slots starts within d after t, and t is not in one of them
+func beforePricePoints(points []PricePoint, t time.Time, d time.Duration) bool {
+	if inPricePoints(points, t) {
+		return false
+	}
+	for _, p := range points {
+		if p.Start.After(t) && !p.Start.After(t.Add(d)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeatingControllerRelease(t *testing.T) {
+	now := time.Date(2026, 10, 16, 16, 0, 0, 0, priceLocation)
+	c := &HeatingController{ComfortMin: 20, ComfortMax: 23, Boost: 2, Override: 3 * time.Hour}
+
+	// Pre-heating raises the setpoint from 350 to 370
+	paused, setpoint := c.Step(HeatingInput{Now: now, Preheat: true, Room: 21, Setpoint: 350})
+	if paused || setpoint != 370 {
+		t.Fatalf("pre-heat: got paused %t setpoint %d, want false 370", paused, setpoint)
+	}
+	// Turned off while pre-heating restores the setpoint until the machine has it
+	for i := 0; i < 2; i++ {
+		if paused, setpoint = c.Release(HeatingInput{Now: now, Room: 21, Setpoint: 370}); paused || setpoint != 350 {
+			t.Fatalf("release while pre-heating: got paused %t setpoint %d, want false 350", paused, setpoint)
+		}
+	}
+	if paused, setpoint = c.Release(HeatingInput{Now: now, Room: 21, Setpoint: 350}); paused || setpoint != 350 {
+		t.Fatalf("released: got paused %t setpoint %d, want false 350", paused, setpoint)
+	}
+
+	// Turned off while paused in an expensive slot ends the pause until the machine has it
+	if paused, _ = c.Step(HeatingInput{Now: now, Expensive: true, Room: 21, Setpoint: 350}); !paused {
+		t.Fatal("expensive slot: not paused")
+	}
+	if paused, _ = c.Release(HeatingInput{Now: now, Room: 21, Setpoint: 350, Paused: true}); paused {
+		t.Fatal("release while paused: still paused")
+	}
+	c.Release(HeatingInput{Now: now, Room: 21, Setpoint: 350})
+	// A pause set by hand after that is left alone
+	if paused, _ = c.Release(HeatingInput{Now: now, Room: 21, Setpoint: 350, Paused: true}); !paused {
+		t.Fatal("release of a pause set by hand: unpaused")
+	}
+}
+
+func TestHeatingControllerManualOverride(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation)
+	c := &HeatingController{ComfortMin: 20, ComfortMax: 23, Boost: 2, Override: 3 * time.Hour}
+
+	// Paused by hand outside the expensive slots
+	c.ManualOverride(now)
+	for _, minute := range []int{1, 179} {
+		in := HeatingInput{Now: now.Add(time.Duration(minute) * time.Minute), Room: 21, Setpoint: 350, Paused: true}
+		if paused, _ := c.Step(in); !paused {
+			t.Fatalf("minute %d: pause set by hand was undone", minute)
+		}
+	}
+	in := HeatingInput{Now: now.Add(3 * time.Hour), Room: 21, Setpoint: 350, Paused: true}
+	if paused, _ := c.Step(in); paused {
+		t.Fatal("override expired: still paused outside the expensive slots")
+	}
+}
+
+func TestHeatingControllerRestartWhilePreheating(t *testing.T) {
+	now := time.Date(2026, 10, 16, 15, 0, 0, 0, priceLocation)
+	path := filepath.Join(t.TempDir(), "heating.json")
+	open := func() *HeatingController {
+		c, err := OpenHeatingController(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ComfortMin, c.ComfortMax, c.Boost, c.Override = 20, 23, 2, 3*time.Hour
+		return c
+	}
+
+	if _, setpoint := open().Step(HeatingInput{Now: now, Preheat: true, Room: 21, Setpoint: 350}); setpoint != 370 {
+		t.Fatalf("pre-heat: got setpoint %d, want 370", setpoint)
+	}
+	// Restarted while pre-heating the raised setpoint is not boosted again
+	c := open()
+	if _, setpoint := c.Step(HeatingInput{Now: now.Add(time.Minute), Preheat: true, Room: 21, Setpoint: 370}); setpoint != 370 {
+		t.Fatalf("pre-heat after a restart: got setpoint %d, want 370", setpoint)
+	}
+	if _, setpoint := c.Step(HeatingInput{Now: now.Add(time.Hour), Expensive: true, Room: 21, Setpoint: 370}); setpoint != 350 {
+		t.Fatalf("pre-heat over after a restart: got setpoint %d, want 350", setpoint)
+	}
+
+	// Restarted while paused the pause is still the controller's to end
+	if paused, _ := open().Release(HeatingInput{Now: now.Add(time.Hour), Room: 21, Setpoint: 350, Paused: true}); paused {
+		t.Fatal("release after a restart while paused: still paused")
+	}
+}
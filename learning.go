@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Segments shorter than this are too noisy to learn from
+	minLearnSegment = 15 * time.Minute
+	// Readings further apart than this end the segment
+	maxLearnGap = 10 * time.Minute
+	// Drops faster than this in C per hour are hot water being used, not standby loss
+	maxStandbyLoss = 5.0
+	// Drops are measured against the reading at most this long ago
+	drawWindow = 10 * time.Minute
+	// A heating segment ends when the tank has not risen for this long
+	maxHeatStall = 15 * time.Minute
+	// The learned rates follow the last this many hours of segments
+	learnHorizon = 48.0
+	// Rates of a band are used once this many hours have been seen
+	minLearnedHours = 2.0
+)
+
+// TankRates are the learned rates of the DHW tank in one outdoor temperature band
+type TankRates struct {
+	HeatRate     float64 `json:"heatrate"`
+	HeatHours    float64 `json:"heathours"`
+	StandbyLoss  float64 `json:"standbyloss"`
+	StandbyHours float64 `json:"standbyhours"`
+}
+
+// tankSegment is a run of readings with the same heating state. The rate is learned from start
+// to end, which for heating is the last reading the tank rose. A segment is done once it has
+// nothing more to learn, and one with a draw of hot water is not learned but started over.
+type tankSegment struct {
+	heating   bool
+	start     time.Time
+	startTemp float64
+	outdoor   float64
+	end       time.Time
+	endTemp   float64
+	seen      time.Time
+	checkTime time.Time
+	checkTemp float64
+	draw      bool
+	done      bool
+	learned   bool
+}
+
+// Add a reading to the segment
+func (seg *tankSegment) add(t time.Time, tankTemp, setpoint float64) {
+	seg.seen = t
+	if seg.done {
+		return
+	}
+	if seg.checkTemp-tankTemp > maxStandbyLoss*drawWindow.Hours() {
+		seg.draw = true
+		seg.done = true
+		return
+	}
+	if t.Sub(seg.checkTime) >= drawWindow {
+		seg.checkTime, seg.checkTemp = t, tankTemp
+	}
+	if !seg.heating {
+		seg.end, seg.endTemp = t, tankTemp
+		return
+	}
+	if tankTemp > seg.endTemp {
+		seg.end, seg.endTemp = t, tankTemp
+	}
+	// The heat-up rate is only seen until the tank is at its setpoint or stops rising
+	if tankTemp >= setpoint || t.Sub(seg.end) >= maxHeatStall {
+		seg.done = true
+	}
+}
+
+// TankLearner learns the heat-up rate and standby loss of the DHW tank per outdoor temperature
+// band from the readings, and keeps them in the file at Path
+type TankLearner struct {
+	Path      string
+	BandWidth float64
+
+	mu      sync.Mutex
+	bands   map[int]*TankRates
+	segment *tankSegment
+}
+
+// OpenTankLearner reads the learned rates from path. A missing file starts from scratch.
+func OpenTankLearner(path string, bandWidth float64) (*TankLearner, error) {
+	if bandWidth <= 0 {
+		bandWidth = 5
+	}
+	l := &TankLearner{Path: path, BandWidth: bandWidth, bands: make(map[int]*TankRates)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	return l, json.Unmarshal(data, &l.bands)
+}
+
+// Band of an outdoor temperature
+func (l *TankLearner) band(outdoor float64) int {
+	return int(math.Floor(outdoor / l.BandWidth))
+}
+
+// Record a reading of the tank temperature. heating tells if DHW production is not paused, and
+// setpoint is its desired temperature.
+func (l *TankLearner) Record(t time.Time, tankTemp, setpoint, outdoor float64, heating bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seg := l.segment
+	if seg != nil && seg.heating == heating && t.Sub(seg.seen) <= maxLearnGap {
+		seg.add(t, tankTemp, setpoint)
+		switch {
+		case seg.draw:
+			// Learn from after the draw instead
+			l.segment = newTankSegment(heating, t, tankTemp, outdoor)
+		case seg.done:
+			l.finish(seg)
+		}
+		return
+	}
+	if seg != nil {
+		l.finish(seg)
+	}
+	l.segment = newTankSegment(heating, t, tankTemp, outdoor)
+	l.segment.add(t, tankTemp, setpoint)
+}
+
+func newTankSegment(heating bool, t time.Time, tankTemp, outdoor float64) *tankSegment {
+	return &tankSegment{heating: heating, start: t, startTemp: tankTemp, outdoor: outdoor, end: t, endTemp: tankTemp, seen: t, checkTime: t, checkTemp: tankTemp}
+}
+
+// Learn from a segment once, unless it has a draw
+func (l *TankLearner) finish(seg *tankSegment) {
+	if seg.learned || seg.draw {
+		return
+	}
+	seg.learned = true
+	if err := l.learn(seg); err != nil {
+		log.Printf("error writing learned tank rates: %v", err)
+	}
+}
+
+// Update the rates of the band of a finished segment and write them to the file
+func (l *TankLearner) learn(seg *tankSegment) error {
+	d := seg.end.Sub(seg.start)
+	if d < minLearnSegment {
+		return nil
+	}
+	hours := d.Hours()
+	rate := (seg.endTemp - seg.startTemp) / hours
+
+	b := l.band(seg.outdoor)
+	r, ok := l.bands[b]
+	if !ok {
+		r = &TankRates{}
+		l.bands[b] = r
+	}
+	switch {
+	case seg.heating && rate > 0:
+		r.HeatRate, r.HeatHours = learnRate(r.HeatRate, r.HeatHours, rate, hours)
+	case !seg.heating && rate <= 0 && -rate < maxStandbyLoss:
+		r.StandbyLoss, r.StandbyHours = learnRate(r.StandbyLoss, r.StandbyHours, -rate, hours)
+	default:
+		return nil
+	}
+	log.Printf("Learned tank rates at %.0f to %.0f C outdoor: heating %.2f C/h, standby loss %.2f C/h", float64(b)*l.BandWidth, float64(b+1)*l.BandWidth, r.HeatRate, r.StandbyLoss)
+
+	data, err := json.MarshalIndent(l.bands, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.Path, data, 0666)
+}
+
+// Average of the rate seen over seen hours and a new rate over hours, weighted by time and
+// forgetting what is older than learnHorizon
+func learnRate(rate, seen, newRate, hours float64) (float64, float64) {
+	seen = math.Min(seen, learnHorizon)
+	return (rate*seen + newRate*hours) / (seen + hours), seen + hours
+}
+
+// Model returns the tank model at an outdoor temperature, using the rates of fallback where
+// too little has been learned
+func (l *TankLearner) Model(outdoor float64, fallback TankModel) TankModel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m := fallback
+	if r, ok := l.bands[l.band(outdoor)]; ok {
+		if r.HeatHours >= minLearnedHours {
+			m.HeatRate = r.HeatRate
+		}
+		if r.StandbyHours >= minLearnedHours {
+			m.StandbyLoss = r.StandbyLoss
+		}
+	}
+	return m
+}
+
+// String lists the learned rates per outdoor temperature band
+func (l *TankLearner) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bands := make([]int, 0, len(l.bands))
+	for b := range l.bands {
+		bands = append(bands, b)
+	}
+	sort.Ints(bands)
+	s := "outdoor C       heating C/h  hours  standby loss C/h  hours\n"
+	for _, b := range bands {
+		r := l.bands[b]
+		s += fmt.Sprintf("%5.0f to %5.0f  %11.2f  %5.1f  %16.2f  %5.1f\n", float64(b)*l.BandWidth, float64(b+1)*l.BandWidth, r.HeatRate, r.HeatHours, r.StandbyLoss, r.StandbyHours)
+	}
+	return s
+}
+
+// Temperature in C of a register value in C times 10, which is negative above 32767
+func registerTemperature(v int) float64 {
+	if v > math.MaxInt16 {
+		v -= math.MaxUint16 + 1
+	}
+	return float64(v) / 10
+}
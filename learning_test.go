@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTankLearnerSegments(t *testing.T) {
+	start := time.Date(2026, 10, 16, 0, 0, 0, 0, priceLocation)
+	type reading struct {
+		temp    float64
+		heating bool
+	}
+	tests := []struct {
+		name     string
+		readings func(minute int) reading
+		minutes  int
+		heat     float64
+		standby  float64
+	}{
+		{
+			// 40 to 50 C in an hour, then two hours at the setpoint
+			name: "heating until setpoint",
+			readings: func(m int) reading {
+				if m >= 180 {
+					return reading{50, false}
+				}
+				return reading{math.Min(40+float64(m)/6, 50), true}
+			},
+			minutes: 190,
+			heat:    10,
+		},
+		{
+			// 40 to 45 C in half an hour, then stuck below the setpoint for two hours
+			name: "heating until it stops rising",
+			readings: func(m int) reading {
+				if m >= 150 {
+					return reading{45, false}
+				}
+				return reading{math.Min(40+float64(m)/6, 45), true}
+			},
+			minutes: 160,
+			heat:    10,
+		},
+		{
+			// Losing 0.5 C/h for four hours with a shower drawing 6 C in 10 minutes after two hours
+			name: "standby with a draw",
+			readings: func(m int) reading {
+				temp := 50 - 0.5*float64(m)/60
+				switch {
+				case m >= 130:
+					temp -= 6
+				case m >= 120:
+					temp -= 0.6 * float64(m-120)
+				}
+				if m >= 240 {
+					return reading{temp, true}
+				}
+				return reading{temp, false}
+			},
+			minutes: 250,
+			standby: 0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := OpenTankLearner(filepath.Join(t.TempDir(), "tank.json"), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for m := 0; m <= tt.minutes; m++ {
+				r := tt.readings(m)
+				l.Record(start.Add(time.Duration(m)*time.Minute), r.temp, 50, 7, r.heating)
+			}
+			rates := l.bands[l.band(7)]
+			if rates == nil {
+				rates = &TankRates{}
+			}
+			if math.Abs(rates.HeatRate-tt.heat) > 0.1 {
+				t.Errorf("learned heat-up rate %.2f C/h, want %.2f", rates.HeatRate, tt.heat)
+			}
+			if math.Abs(rates.StandbyLoss-tt.standby) > 0.01 {
+				t.Errorf("learned standby loss %.2f C/h, want %.2f", rates.StandbyLoss, tt.standby)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Modbus function codes served by ModbusServer
+const (
+	modbusReadHoldingRegisters   = 0x03
+	modbusReadInputRegisters     = 0x04
+	modbusWriteSingleRegister    = 0x06
+	modbusWriteMultipleRegisters = 0x10
+)
+
+// Modbus exception codes
+const (
+	modbusIllegalFunction    = 0x01
+	modbusIllegalDataAddress = 0x02
+	modbusIllegalDataValue   = 0x03
+	modbusDeviceFailure      = 0x04
+)
+
+// ModbusServer serves the CTS700 registers used by nilan.Controller over Modbus TCP from
+// Device, so the nilan package can be run against a simulator over the wire. It reports the
+// machine as a GEO model with the room temperature from T3. Slave 1 holds the ventilation and
+// DHW registers and slave 4 the central heating registers, as on the machine.
+type ModbusServer struct {
+	Addr   string
+	Device Controller
+}
+
+// modbusException is an error answered with a Modbus exception code
+type modbusException byte
+
+func (e modbusException) Error() string {
+	return fmt.Sprintf("modbus exception %d", byte(e))
+}
+
+// ListenAndServe listens on Addr and serves connections until listening fails
+func (s *ModbusServer) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve connections on l until accepting fails
+func (s *ModbusServer) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Answer the requests on conn until it is closed
+func (s *ModbusServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("modbus server: %v", err)
+			}
+			return
+		}
+		// MBAP header: transaction id, protocol id, length of unit id and PDU, unit id
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			log.Printf("modbus server: bad header % x", header)
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			log.Printf("modbus server: %v", err)
+			return
+		}
+
+		resp, err := s.handle(header[6], pdu)
+		if err != nil {
+			var ex modbusException
+			if !errors.As(err, &ex) {
+				log.Printf("modbus server: %v", err)
+				ex = modbusDeviceFailure
+			}
+			resp = []byte{pdu[0] | 0x80, byte(ex)}
+		}
+		out := make([]byte, 7, 7+len(resp))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(resp)+1))
+		out[6] = header[6]
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			log.Printf("modbus server: %v", err)
+			return
+		}
+	}
+}
+
+// Return the response PDU to the request pdu for slave
+func (s *ModbusServer) handle(slave byte, pdu []byte) ([]byte, error) {
+	switch pdu[0] {
+	case modbusReadHoldingRegisters, modbusReadInputRegisters:
+		if len(pdu) != 5 {
+			return nil, modbusException(modbusIllegalDataValue)
+		}
+		addr := binary.BigEndian.Uint16(pdu[1:3])
+		quantity := binary.BigEndian.Uint16(pdu[3:5])
+		if quantity < 1 || quantity > 125 {
+			return nil, modbusException(modbusIllegalDataValue)
+		}
+		values, err := s.registers(slave)
+		if err != nil {
+			return nil, err
+		}
+		resp := []byte{pdu[0], byte(2 * quantity)}
+		for i := uint16(0); i < quantity; i++ {
+			v, ok := values[nilan.Register(addr+i)]
+			if !ok {
+				return nil, modbusException(modbusIllegalDataAddress)
+			}
+			resp = binary.BigEndian.AppendUint16(resp, v)
+		}
+		return resp, nil
+
+	case modbusWriteSingleRegister:
+		if len(pdu) != 5 {
+			return nil, modbusException(modbusIllegalDataValue)
+		}
+		addr := binary.BigEndian.Uint16(pdu[1:3])
+		if err := s.write(slave, nilan.Register(addr), binary.BigEndian.Uint16(pdu[3:5])); err != nil {
+			return nil, err
+		}
+		return pdu, nil
+
+	case modbusWriteMultipleRegisters:
+		if len(pdu) < 6 {
+			return nil, modbusException(modbusIllegalDataValue)
+		}
+		addr := binary.BigEndian.Uint16(pdu[1:3])
+		quantity := binary.BigEndian.Uint16(pdu[3:5])
+		if quantity < 1 || quantity > 123 || int(pdu[5]) != 2*int(quantity) || len(pdu) != 6+2*int(quantity) {
+			return nil, modbusException(modbusIllegalDataValue)
+		}
+		for i := uint16(0); i < quantity; i++ {
+			v := binary.BigEndian.Uint16(pdu[6+2*i:])
+			if err := s.write(slave, nilan.Register(addr+i), v); err != nil {
+				return nil, err
+			}
+		}
+		return pdu[:5], nil
+	}
+	return nil, modbusException(modbusIllegalFunction)
+}
+
+// Return the registers of slave with the values of Device
+func (s *ModbusServer) registers(slave byte) (map[nilan.Register]uint16, error) {
+	r, err := s.Device.FetchReadings()
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.Device.FetchSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	switch slave {
+	case 1:
+		values := map[nilan.Register]uint16{
+			nilan.FanSpeedRegister:                       uint16(*st.FanSpeed),
+			nilan.DesiredRoomTemperatureRegister:         uint16(*st.DesiredRoomTemperature),
+			nilan.MasterTemperatureSensorSettingRegister: 0,
+			nilan.T3ExtractAirTemperatureRegister:        uint16(int16(r.RoomTemperature)),
+			nilan.TextRoomTemperatureRegister:            uint16(int16(r.RoomTemperature)),
+			nilan.OutdoorTemperatureRegister:             uint16(int16(r.OutdoorTemperature)),
+			nilan.AverageHumidityRegister:                uint16(r.AverageHumidity),
+			nilan.ActualHumidityRegister:                 uint16(r.ActualHumidity),
+			nilan.DHWTopTankTemperatureRegister:          uint16(int16(r.DHWTankTopTemperature)),
+			nilan.DHWBottomTankTemperatureRegister:       uint16(int16(r.DHWTankBottomTemperature)),
+			nilan.DHWSetPointRegister:                    uint16(*st.DesiredDHWTemperature),
+			nilan.DHWPauseRegister:                       modbusBool(*st.DHWProductionPaused),
+			nilan.DHWPauseDurationRegister:               uint16(*st.DHWProductionPauseDuration),
+			nilan.VentilationModeRegister:                uint16(*st.VentilationMode),
+			nilan.VentilationPauseRegister:               modbusBool(*st.VentilationOnPause),
+		}
+		// No events are raised
+		for _, e := range []nilan.Register{
+			nilan.EventOutdoorFilterWarningRegister,
+			nilan.EventExtractFilterWarningRegister,
+			nilan.EventHeaterOverHeatAlarmRegister,
+			nilan.EventHeaterFrostWarningRegister,
+			nilan.EventHeaterFrostLongAlarmRegister,
+			nilan.EventHeaterFrostAlarmRegister,
+			nilan.EventFireThermAlarmRegister,
+			nilan.EventKlixonWarningRegister,
+			nilan.EventCompressHighPressWarning,
+		} {
+			values[e] = 0
+		}
+		return values, nil
+	case 4:
+		return map[nilan.Register]uint16{
+			nilan.CentralHeatingPauseRegister:           modbusBool(*st.CentralHeatingPaused),
+			nilan.CentralHeatingPauseDurationRegister:   uint16(*st.CentralHeatingPauseDuration),
+			nilan.CentralHeatingPowerRegister:           modbusBool(*st.CentralHeatingIsOn),
+			nilan.SetpointSupplyTemperatureRegisterGEO:  uint16(*st.SetpointSupplyTemperature),
+			nilan.SetpointSupplyTemperatureRegisterAIR9: uint16(*st.SetpointSupplyTemperature),
+			nilan.DeviceTypeGEOReigister:                8,
+			nilan.DeviceTypeAIR9Register:                0,
+			nilan.T18ReadingRegisterGEO:                 uint16(int16(r.SupplyFlowTemperature)),
+			nilan.T18ReadingRegisterAIR9:                uint16(int16(r.SupplyFlowTemperature)),
+		}, nil
+	}
+	return nil, modbusException(modbusIllegalDataAddress)
+}
+
+// Write value to register of slave on Device
+func (s *ModbusServer) write(slave byte, register nilan.Register, value uint16) error {
+	var settings nilan.Settings
+	v := int(value)
+	on := value == 1
+	switch {
+	case slave == 1 && register == nilan.FanSpeedRegister:
+		speed := nilan.FanSpeed(value)
+		if speed < nilan.FanSpeedLow || speed > nilan.FanSpeedVeryHigh {
+			return modbusException(modbusIllegalDataValue)
+		}
+		settings.FanSpeed = &speed
+	case slave == 1 && register == nilan.DesiredRoomTemperatureRegister:
+		settings.DesiredRoomTemperature = &v
+	case slave == 1 && register == nilan.DHWSetPointRegister:
+		settings.DesiredDHWTemperature = &v
+	case slave == 1 && register == nilan.DHWPauseRegister:
+		settings.DHWProductionPaused = &on
+	case slave == 1 && register == nilan.DHWPauseDurationRegister:
+		settings.DHWProductionPauseDuration = &v
+	case slave == 1 && register == nilan.VentilationModeRegister:
+		if v > 2 {
+			return modbusException(modbusIllegalDataValue)
+		}
+		settings.VentilationMode = &v
+	case slave == 1 && register == nilan.VentilationPauseRegister:
+		settings.VentilationOnPause = &on
+	case slave == 4 && register == nilan.CentralHeatingPauseRegister:
+		settings.CentralHeatingPaused = &on
+	case slave == 4 && register == nilan.CentralHeatingPauseDurationRegister:
+		settings.CentralHeatingPauseDuration = &v
+	case slave == 4 && register == nilan.CentralHeatingPowerRegister:
+		settings.CentralHeatingIsOn = &on
+	case slave == 4 && (register == nilan.SetpointSupplyTemperatureRegisterGEO || register == nilan.SetpointSupplyTemperatureRegisterAIR9):
+		settings.SetpointSupplyTemperature = &v
+	default:
+		return modbusException(modbusIllegalDataAddress)
+	}
+	return s.Device.SendSettings(settings)
+}
+
+// Return a flag as a register value
+func modbusBool(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+func TestModbusServerWirePath(t *testing.T) {
+	// The simulated time stands still, so the values read over the wire are the simulator's
+	sim := NewSimulator(time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation), 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&ModbusServer{Device: sim}).Serve(l)
+	defer l.Close()
+	c := recoveringController{&nilan.Controller{Config: nilan.Config{NilanAddress: l.Addr().String()}}}
+
+	r, s, err := fetchState(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sim.FetchReadings()
+	for _, v := range []struct {
+		name      string
+		got, want int
+	}{
+		{"RoomTemperature", r.RoomTemperature, want.RoomTemperature},
+		{"OutdoorTemperature", r.OutdoorTemperature, want.OutdoorTemperature},
+		{"AverageHumidity", r.AverageHumidity, want.AverageHumidity},
+		{"ActualHumidity", r.ActualHumidity, want.ActualHumidity},
+		{"DHWTankTopTemperature", r.DHWTankTopTemperature, want.DHWTankTopTemperature},
+		{"DHWTankBottomTemperature", r.DHWTankBottomTemperature, want.DHWTankBottomTemperature},
+		{"SupplyFlowTemperature", r.SupplyFlowTemperature, want.SupplyFlowTemperature},
+	} {
+		if v.got != v.want {
+			t.Errorf("reading %s: got %d, want %d", v.name, v.got, v.want)
+		}
+	}
+	checkSettings(t, "before sending", s, sim)
+
+	speed, dhw, supply, duration := nilan.FanSpeedHigh, 520, 370, 60
+	paused, on := true, true
+	err = c.SendSettings(nilan.Settings{
+		FanSpeed:                   &speed,
+		DesiredDHWTemperature:      &dhw,
+		DHWProductionPaused:        &paused,
+		DHWProductionPauseDuration: &duration,
+		CentralHeatingPaused:       &paused,
+		CentralHeatingIsOn:         &on,
+		SetpointSupplyTemperature:  &supply,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, s, err = fetchState(c); err != nil {
+		t.Fatal(err)
+	}
+	checkSettings(t, "after sending", s, sim)
+	if *s.FanSpeed != speed || *s.DesiredDHWTemperature != dhw || !*s.DHWProductionPaused ||
+		*s.DHWProductionPauseDuration != duration || !*s.CentralHeatingPaused || *s.SetpointSupplyTemperature != supply {
+		t.Errorf("after sending: settings were not written")
+	}
+}
+
+// Check that the settings s read over the wire are the ones of sim
+func checkSettings(t *testing.T, when string, s *nilan.Settings, sim *Simulator) {
+	t.Helper()
+	want, _ := sim.FetchSettings()
+	for _, v := range []struct {
+		name      string
+		got, want int
+	}{
+		{"FanSpeed", int(*s.FanSpeed), int(*want.FanSpeed)},
+		{"DesiredRoomTemperature", *s.DesiredRoomTemperature, *want.DesiredRoomTemperature},
+		{"DesiredDHWTemperature", *s.DesiredDHWTemperature, *want.DesiredDHWTemperature},
+		{"DHWProductionPauseDuration", *s.DHWProductionPauseDuration, *want.DHWProductionPauseDuration},
+		{"CentralHeatingPauseDuration", *s.CentralHeatingPauseDuration, *want.CentralHeatingPauseDuration},
+		{"VentilationMode", *s.VentilationMode, *want.VentilationMode},
+		{"SetpointSupplyTemperature", *s.SetpointSupplyTemperature, *want.SetpointSupplyTemperature},
+	} {
+		if v.got != v.want {
+			t.Errorf("%s: setting %s: got %d, want %d", when, v.name, v.got, v.want)
+		}
+	}
+	for _, v := range []struct {
+		name      string
+		got, want bool
+	}{
+		{"DHWProductionPaused", *s.DHWProductionPaused, *want.DHWProductionPaused},
+		{"CentralHeatingPaused", *s.CentralHeatingPaused, *want.CentralHeatingPaused},
+		{"CentralHeatingIsOn", *s.CentralHeatingIsOn, *want.CentralHeatingIsOn},
+		{"VentilationOnPause", *s.VentilationOnPause, *want.VentilationOnPause},
+	} {
+		if v.got != v.want {
+			t.Errorf("%s: setting %s: got %t, want %t", when, v.name, v.got, v.want)
+		}
+	}
+}
@@ -1,19 +1,17 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/brutella/hc"
 	"github.com/brutella/hc/accessory"
 	"github.com/brutella/hc/characteristic"
 	"github.com/brutella/hc/service"
-	"github.com/gocolly/colly"
 	"github.com/pjuzeliunas/nilan"
 	"github.com/theherk/viper"
 )
@@ -21,6 +19,7 @@ import (
 // Nilan CTS700 accessory
 type Nilan struct {
 	*accessory.Accessory
+	Controller Controller
 
 	CentralHeatingSwitch  *service.Switch
 	VentilationThermostat *NilanFanThermostat
@@ -34,6 +33,12 @@ type Nilan struct {
 	MustHeatTemperatureDifference *service.Thermostat
 	StopHeatTemperatureDifference *service.Thermostat
 	RunHours                      *service.Thermostat
+	ContiguousHeatingSwitch       *service.Switch
+	HumidityBoostSwitch           *service.Switch
+	ElectricPrice                 *NilanPriceSensor
+	SettingsAlert                 *service.ContactSensor
+	// DeviceFaults are set while the machine can not be read
+	DeviceFaults []*characteristic.StatusFault
 }
 
 // NilanFanThermostat service
@@ -53,6 +58,23 @@ func NewNilanFanThermostat() *NilanFanThermostat {
 	return &svc
 }
 
+// NilanPriceSensor service
+type NilanPriceSensor struct {
+	*service.TemperatureSensor
+	StatusFault *characteristic.StatusFault
+}
+
+// NewNilanPriceSensor instantiates electric price service, faulted while running on fallback prices
+func NewNilanPriceSensor() *NilanPriceSensor {
+	svc := NilanPriceSensor{}
+	svc.TemperatureSensor = service.NewTemperatureSensor()
+
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	return &svc
+}
+
 // NilanFan service
 type NilanFan struct {
 	*service.FanV2
@@ -78,12 +100,42 @@ var (
 	runHours                      int
 	mustHeatTemperatureDifference int
 	stopHeatTemperatureDifference int
-	//celiusHours                   float64
+	priceProviderName             string
+	priceArea                     string
+	priceResolution               time.Duration
+	priceTariff                   Tariff
+	priceCachePath                string
+	fallbackNightFrom             int
+	fallbackNightTo               int
+	pricePublishHour              int
+	priceMinRetry                 time.Duration
+	priceMaxRetry                 time.Duration
+	heatInBlocks                  bool
+	maxHeatingBlocks              int
+	minHeatingBlock               time.Duration
+	priceDataset                  string
+	useTankModel                  bool
+	celiusHours                   float64
+	standbyLoss                   float64
+	tankLearner                   *TankLearner
+	comfortDeadlines              []Deadline
+	heatingPlanner                *RollingPlanner
+	cycleGuard                    = &CycleGuard{}
+	dhwController                 = &DHWController{Guard: cycleGuard}
+	useHeatingOptimizer           bool
+	heatingPauseTime              time.Duration
+	heatingPreheatTime            time.Duration
+	heatingSetpoint               string
+	heatingController             = &HeatingController{}
+	useVentilationOptimizer       bool
+	ventilationExpensiveTime      time.Duration
+	ventilationController         = &VentilationController{}
+	useHumidityBoost              bool
 )
 
 // NewNilan sets Nilan accessory instance up
-func NewNilan(info accessory.Info) *Nilan {
-	acc := Nilan{}
+func NewNilan(info accessory.Info, c Controller) *Nilan {
+	acc := Nilan{Controller: c}
 	acc.Accessory = accessory.New(info, accessory.TypeHeater)
 
 	//start auto save power mode components
@@ -150,6 +202,36 @@ func NewNilan(info accessory.Info) *Nilan {
 			return
 		}
 	})
+
+	acc.ContiguousHeatingSwitch = service.NewSwitch()
+	acc.ContiguousHeatingSwitch.AddCharacteristic(newName("Contiguous Heating"))
+	acc.ContiguousHeatingSwitch.On.SetValue(heatInBlocks)
+	acc.ContiguousHeatingSwitch.On.OnValueRemoteUpdate(func(on bool) {
+		log.Printf("Contiguous heating active: %v\n", on)
+		heatInBlocks = on
+		viper.Set("optimizer.blocks", on)
+		viper.WriteConfig()
+	})
+
+	acc.HumidityBoostSwitch = service.NewSwitch()
+	acc.HumidityBoostSwitch.AddCharacteristic(newName("Humidity Boost"))
+	acc.HumidityBoostSwitch.On.SetValue(useHumidityBoost)
+	acc.HumidityBoostSwitch.On.OnValueRemoteUpdate(func(on bool) {
+		log.Printf("Humidity boost active: %v\n", on)
+		useHumidityBoost = on
+		ventilationController.SetBoost(on)
+		viper.Set("boost.on", on)
+		viper.WriteConfig()
+	})
+
+	// Open while the machine does not take the settings sent to it
+	acc.SettingsAlert = service.NewContactSensor()
+	acc.SettingsAlert.AddCharacteristic(newName("Settings Alert"))
+
+	acc.ElectricPrice = NewNilanPriceSensor()
+	acc.ElectricPrice.AddCharacteristic(newName("Electric Price " + priceArea + " (øre/kWh)"))
+	acc.ElectricPrice.CurrentTemperature.SetMinValue(-1000)
+	acc.ElectricPrice.CurrentTemperature.SetMaxValue(10000)
 	//end auto save power mode components
 
 	acc.CentralHeatingSwitch = service.NewSwitch()
@@ -165,35 +247,41 @@ func NewNilan(info accessory.Info) *Nilan {
 			*s.CentralHeatingPauseDuration = 180
 		}
 
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go func() {
+			if err := sendManualSettings(c, cycleGuard, s); err != nil {
+				log.Printf("error setting Central Heating: %v", err)
+				return
+			}
+			heatingController.ManualOverride(clock.Now())
+		}()
 	})
 
 	acc.VentilationThermostat = NewNilanFanThermostat()
 	acc.VentilationThermostat.Primary = true
 	acc.VentilationThermostat.AddCharacteristic(newName("Room Temperature"))
 	acc.VentilationThermostat.TargetHeatingCoolingState.OnValueRemoteUpdate(func(state int) {
-		c := nilanController()
+		c := acc.Controller
 		switch state {
 		case characteristic.TargetHeatingCoolingStateOff:
 			p := true
 			s := nilan.Settings{VentilationOnPause: &p}
-			c.SendSettings(s)
+			go c.SendSettings(s)
 		case characteristic.TargetHeatingCoolingStateHeat:
 			p := false
 			m := 2 // heating
 			s := nilan.Settings{VentilationMode: &m, VentilationOnPause: &p}
-			c.SendSettings(s)
+			go c.SendSettings(s)
 		case characteristic.TargetHeatingCoolingStateCool:
 			p := false
 			m := 1 // cooling
 			s := nilan.Settings{VentilationMode: &m, VentilationOnPause: &p}
-			c.SendSettings(s)
+			go c.SendSettings(s)
 		case characteristic.TargetHeatingCoolingStateAuto:
 			p := false
 			m := 0 // auto
 			s := nilan.Settings{VentilationMode: &m, VentilationOnPause: &p}
-			c.SendSettings(s)
+			go c.SendSettings(s)
 		}
 	})
 	acc.VentilationThermostat.TemperatureDisplayUnits.SetValue(characteristic.TemperatureDisplayUnitsCelsius)
@@ -208,8 +296,8 @@ func NewNilan(info accessory.Info) *Nilan {
 			return
 		}
 		s := nilan.Settings{DesiredRoomTemperature: &t}
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go c.SendSettings(s)
 	})
 
 	acc.Fan = NewNilanFan()
@@ -223,8 +311,8 @@ func NewNilan(info accessory.Info) *Nilan {
 			return
 		}
 		s := nilan.Settings{FanSpeed: &speed}
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go c.SendSettings(s)
 	})
 
 	acc.HotWaterSwitch = service.NewSwitch()
@@ -241,8 +329,14 @@ func NewNilan(info accessory.Info) *Nilan {
 			*s.DHWProductionPauseDuration = 180
 		}
 
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go func() {
+			if err := sendManualSettings(c, cycleGuard, s); err != nil {
+				log.Printf("error setting DHW: %v", err)
+				return
+			}
+			dhwController.ManualOverride(clock.Now())
+		}()
 	})
 
 	acc.HotWater = service.NewThermostat()
@@ -261,8 +355,8 @@ func NewNilan(info accessory.Info) *Nilan {
 			return
 		}
 		s := nilan.Settings{DesiredDHWTemperature: &t}
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go c.SendSettings(s)
 	})
 
 	acc.SupplyFlow = service.NewThermostat()
@@ -281,8 +375,8 @@ func NewNilan(info accessory.Info) *Nilan {
 			return
 		}
 		s := nilan.Settings{SetpointSupplyTemperature: &t}
-		c := nilanController()
-		c.SendSettings(s)
+		c := acc.Controller
+		go c.SendSettings(s)
 	})
 
 	acc.OutdoorTemp = service.NewTemperatureSensor()
@@ -290,6 +384,12 @@ func NewNilan(info accessory.Info) *Nilan {
 	acc.OutdoorTemp.CurrentTemperature.SetMinValue(-40)
 	acc.OutdoorTemp.CurrentTemperature.SetMaxValue(160)
 
+	for _, svc := range []*service.Service{acc.VentilationThermostat.Service, acc.OutdoorTemp.Service, acc.HotWater.Service, acc.SupplyFlow.Service} {
+		fault := characteristic.NewStatusFault()
+		svc.AddCharacteristic(fault.Characteristic)
+		acc.DeviceFaults = append(acc.DeviceFaults, fault)
+	}
+
 	acc.AddService(acc.CentralHeatingSwitch.Service)
 	acc.AddService(acc.VentilationThermostat.Service)
 	acc.AddService(acc.OutdoorTemp.Service)
@@ -301,6 +401,10 @@ func NewNilan(info accessory.Info) *Nilan {
 	acc.AddService(acc.MustHeatTemperatureDifference.Service)
 	acc.AddService(acc.StopHeatTemperatureDifference.Service)
 	acc.AddService(acc.RunHours.Service)
+	acc.AddService(acc.ContiguousHeatingSwitch.Service)
+	acc.AddService(acc.HumidityBoostSwitch.Service)
+	acc.AddService(acc.SettingsAlert.Service)
+	acc.AddService(acc.ElectricPrice.Service)
 	return &acc
 }
 
@@ -310,15 +414,45 @@ func newName(n string) *characteristic.Characteristic {
 	return char.Characteristic
 }
 
-func nilanController() nilan.Controller {
+// defaultTankFile keeps the learned tank rates
+const defaultTankFile = "/home/kevin/nilan-hk/tank.json"
+
+// defaultPlanFile keeps the heating slots already committed
+const defaultPlanFile = "/home/kevin/nilan-hk/plan.json"
+
+// defaultHeatingFile keeps the setpoint from before a pre-heat of the central heating
+const defaultHeatingFile = "/home/kevin/nilan-hk/heating.json"
+
+// defaultVentilationFile keeps the fan speed from before lowering or boosting it
+const defaultVentilationFile = "/home/kevin/nilan-hk/ventilation.json"
+
+func nilanController() Controller {
 	conf := nilan.CurrentConfig()
-	return nilan.Controller{Config: conf}
+	return recoveringController{&nilan.Controller{Config: conf}}
 }
 
-func updateReadings(acc *Nilan) {
-	c := nilanController()
-	r, _ := c.FetchReadings()
-	s, _ := c.FetchSettings()
+// Mark the services of the machine as faulted or not
+func (acc *Nilan) setDeviceFault(faulted bool) {
+	v := characteristic.StatusFaultNoFault
+	if faulted {
+		v = characteristic.StatusFaultGeneralFault
+	}
+	for _, f := range acc.DeviceFaults {
+		f.SetValue(v)
+	}
+}
+
+func updateReadings(acc *Nilan) error {
+	r, s, err := fetchState(acc.Controller)
+	if err != nil {
+		acc.setDeviceFault(true)
+		return err
+	}
+	acc.setDeviceFault(false)
+
+	if tankLearner != nil {
+		tankLearner.Record(clock.Now(), registerTemperature(r.DHWTankTopTemperature), registerTemperature(*s.DesiredDHWTemperature), registerTemperature(r.OutdoorTemperature), !*s.DHWProductionPaused)
+	}
 
 	if *s.CentralHeatingIsOn && !*s.CentralHeatingPaused {
 		acc.CentralHeatingSwitch.On.SetValue(true)
@@ -370,267 +504,287 @@ func updateReadings(acc *Nilan) {
 	acc.SupplyFlow.TargetTemperature.SetValue(float64(*s.SetpointSupplyTemperature) / 10.0)
 
 	acc.OutdoorTemp.CurrentTemperature.SetValue(float64(r.OutdoorTemperature) / 10.0)
+	return nil
 }
 
 func startUpdatingReadings(ac *Nilan, freq time.Duration) {
-	defer func() {
-		if r := recover(); r != nil {
+	failures := 0
+	for {
+		if err := updateReadings(ac); err != nil {
 			// In case of failure: waiting and trying again
-			log.Printf("Sync with Nilan did fail: %v\n", r)
-			time.Sleep(freq)
-			startUpdatingReadings(ac, freq)
+			failures++
+			log.Printf("Sync with Nilan did fail %d times in a row: %v\n", failures, err)
+		} else if failures > 0 {
+			log.Printf("Sync with Nilan works again after %d failures\n", failures)
+			failures = 0
 		}
-	}()
-	for {
-		updateReadings(ac)
 		time.Sleep(freq) // 5 sec delay
 	}
 }
 
 // Configure when to start the heating
-func autoConfigure(freq time.Duration) {
-
-	c := nilanController()
-
-	var runOnce, initialOnce bool
-	runOnce = true
-	initialOnce = true
-	//runOnce2 = true
-	lowestThreePrices := make([]float64, runHours)
-	lowestThreeHours := make([]int, runHours)
+func autoConfigure(acc *Nilan, c Controller, freq time.Duration) {
+	priceProvider := newPriceProvider(priceProviderName, priceDataset)
+	if priceCachePath != "" {
+		cache, err := OpenPriceCache(priceCachePath)
+		if err != nil {
+			log.Printf("error reading price cache: %v", err)
+		}
+		priceProvider = &CachedProvider{Provider: priceProvider, Cache: cache}
+	}
+	m := &saveMode{
+		acc: acc,
+		c:   c,
+		scheduler: &PriceScheduler{
+			Provider:    priceProvider,
+			Area:        priceArea,
+			PublishHour: pricePublishHour,
+			MinRetry:    priceMinRetry,
+			MaxRetry:    priceMaxRetry,
+		},
+		planner:     heatingPlanner,
+		dhw:         dhwController,
+		guard:       cycleGuard,
+		heating:     heatingController,
+		ventilation: ventilationController,
+		runTime:     time.Duration(runHours) * time.Hour,
+	}
 
 	for {
-		dt := time.Now()
-
-		log.Printf("get lowest price once is %t", runOnce)
-		// Get lowest electric price from andel energi
-		if (dt.Local().Hour() == 20 && runOnce) || initialOnce {
-			scrapUrl := "https://andelenergi.dk/kundeservice/aftaler-og-priser/timepris/"
-			lowestThreeHours, lowestThreePrices, _ = GetLowestPriceHours(scrapUrl, runHours)
-			runOnce = false
-
-		} else if dt.Local().Hour() != 20 {
-			runOnce = true
+		if err := m.tick(clock.Now()); err != nil {
+			clock.Sleep(freq)
+			continue
 		}
 
-		r, _ := c.FetchReadings()
-		s, _ := c.FetchSettings()
-		/* 		if (dt.Local().Hour() == 0 && runOnce2) || initialOnce {
-		   			runHours = int(math.Round(float64(*s.DesiredDHWTemperature-r.DHWTankTopTemperature) / (celiusHours * 10)))
-		   			runOnce2 = false
-		   		} else if dt.Local().Hour() != 0 {
-		   			runOnce2 = true
-		   		} */
-
-		initialOnce = false
-		log.Println("The lowest electric price hours are:")
-
-		log.Println(lowestThreeHours)
+		// Wake up at the end of the current slot so heating follows the slot boundaries
+		sleep := freq
+		now := clock.Now()
+		if next := nextSlotBoundary(m.scheduler.Known(), now); !next.IsZero() && next.Sub(now) < sleep {
+			sleep = next.Sub(now)
+		}
+		clock.Sleep(sleep)
+	}
+}
 
-		log.Println("The lowest electric price are:")
+// saveMode is the power save loop of autoConfigure with the controllers it drives and what it
+// keeps between ticks
+type saveMode struct {
+	acc         *Nilan
+	c           Controller
+	scheduler   *PriceScheduler
+	planner     *RollingPlanner
+	dhw         *DHWController
+	guard       *CycleGuard
+	heating     *HeatingController
+	ventilation *VentilationController
+
+	runTime      time.Duration
+	runTimeStart time.Time
+	failures     int
+}
 
-		log.Println(lowestThreePrices)
+// Run the save mode once at now, returning the error when the machine can not be read
+func (m *saveMode) tick(now time.Time) error {
+	// Get the electric prices when new ones are due
+	m.scheduler.Update(now)
+	plan := m.scheduler.Current()
+	prices := m.scheduler.Known()
+	log.Printf("Prices from %s are final: %t, tomorrow's prices are final: %t", plan.Start.Format("2006-01-02 15:04"), plan.Final, m.scheduler.TomorrowFinal())
+	if plan.Err != nil {
+		log.Printf("Save mode is running on fallback prices: %v", plan.Err)
+		m.acc.ElectricPrice.StatusFault.SetValue(characteristic.StatusFaultGeneralFault)
+	} else {
+		m.acc.ElectricPrice.StatusFault.SetValue(characteristic.StatusFaultNoFault)
+	}
 
-		//If it's in the hours of heating
-		inHoursHeating := false
-		for i := 0; i < runHours; i++ {
-			if lowestThreeHours[i] == dt.Local().Hour() {
-				inHoursHeating = true
-			}
+	r, s, err := fetchState(m.c)
+	if err != nil {
+		m.failures++
+		log.Printf("Skipping save mode, reading Nilan did fail %d times in a row: %v", m.failures, err)
+		return err
+	}
+	m.failures = 0
+	m.guard.Observe(CircuitCentralHeating, !*s.CentralHeatingPaused)
+
+	// Estimate the run time from the tank temperature once per planning window
+	if !useTankModel {
+		m.runTime = time.Duration(runHours) * time.Hour
+		m.runTimeStart = time.Time{}
+	} else if !m.runTimeStart.Equal(plan.Start) {
+		model := currentTankModel(registerTemperature(r.OutdoorTemperature))
+		m.runTimeStart = plan.Start
+		if t, err := model.RunTime(float64(*s.DesiredDHWTemperature)/10, float64(r.DHWTankTopTemperature)/10, plan.Start.AddDate(0, 0, 1).Sub(now)); err != nil {
+			m.runTime = time.Duration(runHours) * time.Hour
+			log.Printf("error in config: %v, using run hours %d", err, runHours)
+		} else {
+			m.runTime = t
+			log.Printf("Tank model heating %.2f C/h and losing %.2f C/h gives run time %v", model.HeatRate, model.StandbyLoss, m.runTime)
 		}
-
-		if inHoursHeating || (*s.DesiredDHWTemperature-r.DHWTankTopTemperature)/10 >= mustHeatTemperatureDifference {
-			log.Printf("night:hot water temperature settting is %v and actual temperature is %v and production pause is %v", *s.DesiredDHWTemperature, r.DHWTankTopTemperature, *s.DHWProductionPaused)
-			if *s.DHWProductionPaused && isAutoSavePowerMode {
-				log.Printf("Open the hot water")
-				s := nilan.Settings{}
-				p := false
-				s.DHWProductionPaused = &p
-				s.DHWProductionPauseDuration = new(int)
-				*s.DHWProductionPauseDuration = 0
-				c.SendSettings(s)
+		m.acc.RunHours.CurrentTemperature.SetValue(m.runTime.Hours())
+	}
+	// Plan over all known prices, today with the run time of now and the days after with a full day
+	lowestPrices := m.planner.Plan(prices, now, func(day time.Time) time.Duration {
+		if day.Equal(plan.Start) {
+			return m.runTime
+		}
+		if useTankModel {
+			model := currentTankModel(registerTemperature(r.OutdoorTemperature))
+			desired := float64(*s.DesiredDHWTemperature) / 10
+			// Without a heat-up rate the run hours are used, the config error is logged above
+			if t, err := model.RunTime(desired, desired, day.AddDate(0, 0, 1).Sub(day)); err == nil {
+				return t
 			}
+		}
+		return time.Duration(runHours) * time.Hour
+	}, func(points []PricePoint, d time.Duration) []PricePoint {
+		return selectHeatingSlots(points, d, heatInBlocks, maxHeatingBlocks, minHeatingBlock)
+	})
+	if len(comfortDeadlines) > 0 && len(prices) > 0 {
+		model := currentTankModel(registerTemperature(r.OutdoorTemperature))
+		lowestPrices = deadlineSlots(lowestPrices, prices, now, registerTemperature(r.DHWTankTopTemperature), model, comfortDeadlines, prices[len(prices)-1].End)
+	}
+	if err := m.planner.Commit(lowestPrices, now); err != nil {
+		log.Printf("error saving the heating plan: %v", err)
+	}
 
+	log.Printf("The lowest electric price hours in %s are:", priceArea)
+	for _, p := range lowestPrices {
+		if p.Estimated {
+			log.Printf("%s-%s: %.3f %s (estimated)", p.Start.Format("2006-01-02 15:04"), p.End.Format("15:04"), p.Price, p.Currency)
 		} else {
-			log.Printf("day:hot water temperature settting is %v and actual temperature is %v and production pause is %v", *s.DesiredDHWTemperature, r.DHWTankTopTemperature, *s.DHWProductionPaused)
-			if (*s.DesiredDHWTemperature-r.DHWTankTopTemperature)/10 < stopHeatTemperatureDifference && !*s.DHWProductionPaused && isAutoSavePowerMode {
-				log.Printf("Close the hot water")
-				s := nilan.Settings{}
-				p := true
-				s.DHWProductionPaused = &p
-				s.DHWProductionPauseDuration = new(int)
-				*s.DHWProductionPauseDuration = 180
-				c.SendSettings(s)
-			}
+			log.Printf("%s-%s: %.3f %s", p.Start.Format("2006-01-02 15:04"), p.End.Format("15:04"), p.Price, p.Currency)
 		}
-		time.Sleep((freq))
 	}
 
-}
-
-// Return three lowest price hour
-func GetLowestPriceHours(scrapURL string, runHours int) ([]int, []float64, error) {
-	//define struct to accept json data
-	type DateAndDay struct {
-		Date string `json:"date"`
-		Day  string `json:"day"`
+	if p, ok := pricePointAt(prices, now); ok {
+		log.Printf("The electric price in %s is %.3f %s", priceArea, p.Price, p.Currency)
+		m.acc.ElectricPrice.CurrentTemperature.SetValue(p.Price * 100)
 	}
 
-	type Earea struct {
-		Labels             []string     `json:"labels"`
-		Values             []string     `json:"values"`
-		ValuesDistribution []string     `json:"valuesDistribution"` //New added for transport expense 20231113
-		Dates              []DateAndDay `json:"dates"`
+	//If it's in the hours of heating
+	on := m.dhw.Step(DHWInput{
+		Now:          now,
+		SaveMode:     isAutoSavePowerMode,
+		Scheduled:    inPricePoints(lowestPrices, now),
+		Deficit:      float64(*s.DesiredDHWTemperature-r.DHWTankTopTemperature) / 10,
+		MustHeat:     float64(mustHeatTemperatureDifference),
+		StopHeat:     float64(stopHeatTemperatureDifference),
+		ProductionOn: !*s.DHWProductionPaused,
+	})
+	log.Printf("%s: hot water temperature settting is %v and actual temperature is %v and production pause is %v", m.dhw.State(), *s.DesiredDHWTemperature, r.DHWTankTopTemperature, *s.DHWProductionPaused)
+	if on == *s.DHWProductionPaused {
+		settings := nilan.Settings{}
+		p := !on
+		settings.DHWProductionPaused = &p
+		if on {
+			// The pause duration is left as it is, 0 is out of its range
+			log.Printf("Open the hot water")
+		} else {
+			log.Printf("Close the hot water")
+			settings.DHWProductionPauseDuration = new(int)
+			*settings.DHWProductionPauseDuration = 180
+		}
+		sendGuardedSettings(m.c, m.guard, settings)
 	}
 
-	type Eall struct {
-		East Earea `json:"east"`
-		West Earea `json:"west"`
+	// Pause the central heating in the most expensive slots and pre-heat before them, and hand
+	// it back when the optimizer is turned off
+	setpoint := s.SetpointSupplyTemperature
+	if heatingSetpoint == HeatingSetpointRoom {
+		setpoint = s.DesiredRoomTemperature
 	}
-
-	c := colly.NewCollector()
-	minvalue := make([]float64, runHours)
-	minhour := make([]int, runHours)
-	for i := 0; i < runHours; i++ {
-		minvalue[i] = 9999
-		minhour[i] = -1
+	heating := HeatingInput{
+		Now:      now,
+		Room:     float64(r.RoomTemperature) / 10,
+		Setpoint: *setpoint,
+		Paused:   *s.CentralHeatingPaused,
 	}
-	c.OnHTML("div#chart-component", func(e *colly.HTMLElement) {
-
-		priceJson := e.Attr("data-chart")
-		var str Eall
-		err := json.Unmarshal([]byte(priceJson), &str)
-		if err != nil {
-			return
+	var paused bool
+	var t int
+	if useHeatingOptimizer && isAutoSavePowerMode {
+		expensive := expensiveSlots(prices, heatingPauseTime)
+		heating.Expensive = inPricePoints(expensive, now)
+		heating.Preheat = beforePricePoints(expensive, now, heatingPreheatTime)
+		paused, t = m.heating.Step(heating)
+	} else {
+		paused, t = m.heating.Release(heating)
+	}
+	settings := nilan.Settings{}
+	if paused != *s.CentralHeatingPaused {
+		settings.CentralHeatingPaused = &paused
+		if paused {
+			settings.CentralHeatingPauseDuration = new(int)
+			*settings.CentralHeatingPauseDuration = 180
 		}
-
-		if strings.TrimSpace(str.East.Dates[len(str.East.Dates)-1].Day) == strconv.Itoa(time.Now().Day()) {
-			for i := 0; i < runHours; i++ {
-				for j := 0; j < 24; j++ {
-					hasCompared := false
-					for k := 0; k <= i; k++ {
-						if j >= 0 && j < 4 {
-							if j+20 == minhour[k] {
-								hasCompared = true
-							}
-						} else {
-							if j-4 == minhour[k] {
-								hasCompared = true
-							}
-						}
-
-					}
-					if !hasCompared {
-						s1, _ := strconv.ParseFloat(str.East.Values[len(str.East.Values)-28+j], 64)
-						ete, _ := strconv.ParseFloat(str.East.ValuesDistribution[len(str.East.ValuesDistribution)-28+j], 64) // add transport expense
-
-						s1 = s1 + ete
-						if s1 < minvalue[i] {
-							if j >= 0 && j < 4 {
-								minvalue[i] = s1
-								minhour[i] = j + 20
-							} else {
-								minvalue[i] = s1
-								minhour[i] = j - 4
-							}
-						}
-					}
-
-				}
-
-			}
-		} else if strings.TrimSpace(str.East.Dates[len(str.East.Dates)-1].Day) == strconv.Itoa(time.Now().Day()+1) {
-			if time.Now().Local().Hour() < 20 {
-				for i := 0; i < runHours; i++ {
-					for j := 0; j < 24; j++ {
-						hasCompared := false
-						for k := 0; k <= i; k++ {
-							if j >= 0 && j < 4 {
-								if j+20 == minhour[k] {
-									hasCompared = true
-								}
-							} else {
-								if j-4 == minhour[k] {
-									hasCompared = true
-								}
-							}
-						}
-						if !hasCompared {
-							s1, _ := strconv.ParseFloat(str.East.Values[len(str.East.Values)-52+j], 64)
-							ete, _ := strconv.ParseFloat(str.East.ValuesDistribution[len(str.East.ValuesDistribution)-52+j], 64) // add transport expense
-							s1 = s1 + ete
-							if s1 < minvalue[i] {
-								if j >= 0 && j < 4 {
-									minvalue[i] = s1
-									minhour[i] = j + 20
-								} else {
-									minvalue[i] = s1
-									minhour[i] = j - 4
-								}
-
-							}
-						}
-
-					}
-
-				}
-			} else {
-				for i := 0; i < runHours; i++ {
-					for j := 0; j < 24; j++ {
-						hasCompared := false
-
-						if j <= 23 && j >= 4 {
-							for k := 0; k <= i; k++ {
-								if j-4 == minhour[k] {
-									hasCompared = true
-								}
-							}
-							if !hasCompared {
-								s1, _ := strconv.ParseFloat(str.East.Values[len(str.East.Values)-28+j], 64)
-								ete, _ := strconv.ParseFloat(str.East.ValuesDistribution[len(str.East.ValuesDistribution)-28+j], 64) // add transport expense
-								s1 = s1 + ete
-								if s1 < minvalue[i] {
-									minvalue[i] = s1
-									minhour[i] = j - 4
-								}
-							}
-						} else {
-							for k := 0; k <= i; k++ {
-								if j+20 == minhour[k] {
-									hasCompared = true
-								}
-							}
-							if !hasCompared {
-								s1, _ := strconv.ParseFloat(str.East.Values[len(str.East.Values)-28+j], 64)
-								ete, _ := strconv.ParseFloat(str.East.ValuesDistribution[len(str.East.ValuesDistribution)-28+j], 64) // add transport expense
-								s1 = s1 + ete
-								if s1 < minvalue[i] {
-									minvalue[i] = s1
-									minhour[i] = j + 20
-								}
-							}
-						}
-					}
-				}
-			}
-
+	}
+	if t != *setpoint {
+		if heatingSetpoint == HeatingSetpointRoom {
+			settings.DesiredRoomTemperature = &t
+		} else {
+			settings.SetpointSupplyTemperature = &t
 		}
+	}
+	if settings != (nilan.Settings{}) {
+		sendGuardedSettings(m.c, m.guard, settings)
+	}
 
+	// Lower the fan speed in the most expensive slots unless it is humid, and boost it when
+	// the humidity rises
+	expensiveVentilation := false
+	if useVentilationOptimizer && isAutoSavePowerMode {
+		expensiveVentilation = inPricePoints(expensiveSlots(prices, ventilationExpensiveTime), now)
+	}
+	speed := m.ventilation.Step(VentilationInput{
+		Now:       now,
+		Expensive: expensiveVentilation,
+		Humidity:  float64(r.ActualHumidity),
+		Speed:     *s.FanSpeed,
 	})
+	if speed != *s.FanSpeed {
+		sendGuardedSettings(m.c, m.guard, nilan.Settings{FanSpeed: &speed})
+	}
+	return nil
+}
 
-	c.OnRequest(func(r *colly.Request) {
-		fmt.Printf("Visiting %s\n", r.URL)
-	})
-	c.OnError(func(r *colly.Response, e error) {
-		fmt.Printf("Error while scraping:%s", e.Error())
-	})
-
-	c.Visit(scrapURL)
-
-	return minhour, minvalue, nil
+// Return the consumer prices of the planning window starting at from in area.
+// Missing prices are filled in by fillMissingPrices.
+func fetchWindowPrices(p PriceProvider, area string, from time.Time) ([]PricePoint, error) {
+	to := from.AddDate(0, 0, 1)
+	// The day before is fetched as well for the fallback
+	history, err := p.FetchPrices(from.AddDate(0, 0, -1), to, area)
+	if err != nil {
+		log.Printf("error fetching prices: %v", err)
+	}
+	var points []PricePoint
+	var latest time.Time
+	for _, pp := range history {
+		if !pp.Start.Before(from) && pp.Start.Before(to) {
+			points = append(points, pp)
+		}
+		if pp.End.After(latest) {
+			latest = pp.End
+		}
+	}
+	if !coversRange(points, from, to) {
+		log.Printf("Prices in %s are missing between %s and %s, using fallback prices", area, from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+		points = fillMissingPrices(points, history, from, to, fallbackNightFrom, fallbackNightTo)
+		if err == nil && len(history) > 0 && !latest.After(from) {
+			err = &PriceError{Kind: ErrPricesStale, Err: fmt.Errorf("latest price in %s ends %s", area, latest.Format("2006-01-02 15:04"))}
+		} else if err == nil {
+			err = &PriceError{Kind: ErrPricesIncomplete, Err: fmt.Errorf("no prices in %s from %s", area, firstMissingPrice(points, from).Format("2006-01-02 15:04"))}
+		}
+	}
+	return resamplePricePoints(priceTariff.Apply(points), priceResolution), err
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	//Create nilan logfile
 	f, err := os.OpenFile("/home/kevin/nilan-log/nilanlogfile"+time.Now().Format("2006-01-02"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -641,8 +795,23 @@ func main() {
 	}
 	defer f.Close()
 
-	//Add 30 seconds delay to wait Nilan machine to start
-	time.Sleep(30 * time.Second)
+	// Run against a simulated machine NILAN_SIMULATOR times as fast as real time
+	var c Controller
+	var sim *Simulator
+	if speed, simulate := os.LookupEnv("NILAN_SIMULATOR"); simulate {
+		factor, err := strconv.ParseFloat(speed, 64)
+		if err != nil || factor <= 0 {
+			log.Fatalf("NILAN_SIMULATOR must be a speed factor like 1 or 60: %q", speed)
+		}
+		log.Printf("Running against a simulated Nilan machine at %vx speed", factor)
+		sim = NewSimulator(time.Now(), factor)
+		c = sim
+		clock = sim
+	} else {
+		c = nilanController()
+		//Add 30 seconds delay to wait Nilan machine to start
+		time.Sleep(30 * time.Second)
+	}
 
 	log.Println("Start the Nilan-hk program!!!")
 	//read config.toml to initialize the variable
@@ -657,17 +826,171 @@ func main() {
 	runHours = viper.GetInt("setting.runhours")
 	mustHeatTemperatureDifference = viper.GetInt("setting.mustheatdf")
 	stopHeatTemperatureDifference = viper.GetInt("setting.stopheatdf")
-	//celiusHours = viper.GetFloat64("setting.celiusperhour")
+	priceProviderName = viper.GetString("prices.provider")
+	priceDataset = viper.GetString("prices.dataset")
+	priceArea = viper.GetString("prices.area")
+	if priceTariff, err = loadTariff(); err != nil {
+		log.Printf("error reading tariff: %v", err)
+	}
+	switch m := viper.GetInt("prices.resolution"); m {
+	case 15, 30, 60:
+		priceResolution = time.Duration(m) * time.Minute
+	default:
+		log.Printf("Unsupported price resolution %d minutes, using 60", m)
+		priceResolution = time.Hour
+	}
+	priceCachePath = viper.GetString("prices.cache")
+	viper.SetDefault("prices.fallbackfrom", 0)
+	viper.SetDefault("prices.fallbackto", 6)
+	fallbackNightFrom = viper.GetInt("prices.fallbackfrom")
+	fallbackNightTo = viper.GetInt("prices.fallbackto")
+	viper.SetDefault("prices.publishhour", 13)
+	viper.SetDefault("prices.retrymin", 5)
+	viper.SetDefault("prices.retrymax", 60)
+	pricePublishHour = viper.GetInt("prices.publishhour")
+	priceMinRetry = time.Duration(viper.GetInt("prices.retrymin")) * time.Minute
+	priceMaxRetry = time.Duration(viper.GetInt("prices.retrymax")) * time.Minute
+	viper.SetDefault("optimizer.maxblocks", 1)
+	viper.SetDefault("optimizer.minblock", 60)
+	heatInBlocks = viper.GetBool("optimizer.blocks")
+	maxHeatingBlocks = viper.GetInt("optimizer.maxblocks")
+	minHeatingBlock = time.Duration(viper.GetInt("optimizer.minblock")) * time.Minute
+	if priceArea != PriceAreaWest && priceArea != PriceAreaEast {
+		log.Printf("Unknown price area %q, using %s", priceArea, PriceAreaEast)
+		priceArea = PriceAreaEast
+	}
+	useTankModel = viper.GetBool("setting.tankmodel")
+	celiusHours = viper.GetFloat64("setting.celiusperhour")
+	standbyLoss = viper.GetFloat64("setting.standbyloss")
+	if comfortDeadlines, err = loadDeadlines(); err != nil {
+		log.Printf("error reading deadlines: %v", err)
+	}
+	if viper.GetBool("learning.on") {
+		viper.SetDefault("learning.file", defaultTankFile)
+		viper.SetDefault("learning.band", 5)
+		tankFile := viper.GetString("learning.file")
+		if sim != nil {
+			tankFile = filepath.Join(os.TempDir(), "nilan-simulator-tank.json")
+		}
+		tankLearner, err = OpenTankLearner(tankFile, viper.GetFloat64("learning.band"))
+		if err != nil {
+			log.Printf("error reading learned tank rates: %v", err)
+		}
+	}
+	viper.SetDefault("cycle.minon", 15)
+	viper.SetDefault("cycle.minoff", 15)
+	viper.SetDefault("cycle.maxstarts", 3)
+	cycleGuard.MinOn = time.Duration(viper.GetInt("cycle.minon")) * time.Minute
+	cycleGuard.MinOff = time.Duration(viper.GetInt("cycle.minoff")) * time.Minute
+	cycleGuard.MaxStarts = viper.GetInt("cycle.maxstarts")
+	viper.SetDefault("dhw.override", 180)
+	dhwController.Override = time.Duration(viper.GetInt("dhw.override")) * time.Minute
+	viper.SetDefault("heating.pausehours", 3)
+	viper.SetDefault("heating.preheat", 120)
+	viper.SetDefault("heating.boost", 2)
+	viper.SetDefault("heating.setpoint", HeatingSetpointSupply)
+	viper.SetDefault("heating.comfortmin", 20)
+	viper.SetDefault("heating.comfortmax", 23)
+	viper.SetDefault("heating.override", 180)
+	useHeatingOptimizer = viper.GetBool("heating.on")
+	heatingPauseTime = time.Duration(viper.GetFloat64("heating.pausehours") * float64(time.Hour))
+	heatingPreheatTime = time.Duration(viper.GetInt("heating.preheat")) * time.Minute
+	heatingSetpoint = viper.GetString("heating.setpoint")
+	if heatingSetpoint != HeatingSetpointSupply && heatingSetpoint != HeatingSetpointRoom {
+		log.Printf("Unknown heating setpoint %q, using %s", heatingSetpoint, HeatingSetpointSupply)
+		heatingSetpoint = HeatingSetpointSupply
+	}
+	viper.SetDefault("heating.file", defaultHeatingFile)
+	heatingFile := viper.GetString("heating.file")
+	if sim != nil {
+		// Keep the simulated setpoints out of the real state
+		heatingFile = ""
+	}
+	if heatingController, err = OpenHeatingController(heatingFile); err != nil {
+		log.Printf("error reading the heating state: %v", err)
+	}
+	heatingController.Boost = viper.GetFloat64("heating.boost")
+	heatingController.ComfortMin = viper.GetFloat64("heating.comfortmin")
+	heatingController.ComfortMax = viper.GetFloat64("heating.comfortmax")
+	heatingController.Override = time.Duration(viper.GetInt("heating.override")) * time.Minute
+	viper.SetDefault("ventilation.expensivehours", 3)
+	viper.SetDefault("ventilation.low", 101)
+	viper.SetDefault("ventilation.ceiling", 70)
+	useVentilationOptimizer = viper.GetBool("ventilation.on")
+	ventilationExpensiveTime = time.Duration(viper.GetFloat64("ventilation.expensivehours") * float64(time.Hour))
+	viper.SetDefault("ventilation.file", defaultVentilationFile)
+	ventilationFile := viper.GetString("ventilation.file")
+	if sim != nil {
+		// Keep the simulated fan speeds out of the real state
+		ventilationFile = ""
+	}
+	if ventilationController, err = OpenVentilationController(ventilationFile); err != nil {
+		log.Printf("error reading the ventilation state: %v", err)
+	}
+	ventilationController.LowSpeed = nilan.FanSpeed(viper.GetInt("ventilation.low"))
+	if !(ventilationController.LowSpeed >= 101 && ventilationController.LowSpeed <= 104) {
+		log.Printf("Invalid low fan speed %d, using 101", ventilationController.LowSpeed)
+		ventilationController.LowSpeed = 101
+	}
+	ventilationController.HumidityCeiling = viper.GetFloat64("ventilation.ceiling")
+	viper.SetDefault("boost.speed", 104)
+	viper.SetDefault("boost.rise", 5)
+	viper.SetDefault("boost.window", 10)
+	viper.SetDefault("boost.settle", 3)
+	viper.SetDefault("boost.max", 60)
+	useHumidityBoost = viper.GetBool("boost.on")
+	ventilationController.SetBoost(useHumidityBoost)
+	ventilationController.BoostSpeed = nilan.FanSpeed(viper.GetInt("boost.speed"))
+	if !(ventilationController.BoostSpeed >= 101 && ventilationController.BoostSpeed <= 104) {
+		log.Printf("Invalid boost fan speed %d, using 104", ventilationController.BoostSpeed)
+		ventilationController.BoostSpeed = 104
+	}
+	ventilationController.BoostRise = viper.GetFloat64("boost.rise")
+	ventilationController.BoostWindow = time.Duration(viper.GetInt("boost.window")) * time.Minute
+	ventilationController.BoostSettle = viper.GetFloat64("boost.settle")
+	ventilationController.BoostMax = time.Duration(viper.GetInt("boost.max")) * time.Minute
+	viper.SetDefault("planner.file", defaultPlanFile)
+	planFile := viper.GetString("planner.file")
+	if sim != nil {
+		// Keep the simulated days out of the real plan and price cache, and fetch prices at the
+		// pace of the wall clock
+		planFile = ""
+		priceCachePath = ""
+		priceMinRetry = time.Duration(float64(priceMinRetry) * sim.Speed)
+		priceMaxRetry = time.Duration(float64(priceMaxRetry) * sim.Speed)
+	}
+	if heatingPlanner, err = OpenRollingPlanner(planFile); err != nil {
+		log.Printf("error reading the heating plan: %v", err)
+	}
+
+	// Read every setting sent back from the machine and send it again until it is taken
+	viper.SetDefault("verify.retries", 3)
+	viper.SetDefault("verify.delay", 2)
+	viper.SetDefault("verify.maxdelay", 30)
+	verified := &VerifiedController{
+		Controller: c,
+		Retries:    viper.GetInt("verify.retries"),
+		MinDelay:   time.Duration(viper.GetInt("verify.delay")) * time.Second,
+		MaxDelay:   time.Duration(viper.GetInt("verify.maxdelay")) * time.Second,
+	}
+	c = verified
 
 	// create an accessory
 	info := accessory.Info{Name: "Nilan"}
-	ac := NewNilan(info)
+	ac := NewNilan(info, c)
+	verified.Report = func(err error) {
+		if err != nil {
+			ac.SettingsAlert.ContactSensorState.SetValue(characteristic.ContactSensorStateContactNotDetected)
+		} else {
+			ac.SettingsAlert.ContactSensorState.SetValue(characteristic.ContactSensorStateContactDetected)
+		}
+	}
 	// set auto power save mode to open
 	ac.AutoPowerSaveModeSwitch.On.SetValue(isAutoSavePowerMode)
 
 	go startUpdatingReadings(ac, 5*time.Second)
 
-	go autoConfigure(60 * time.Second)
+	go autoConfigure(ac, c, 60*time.Second)
 
 	pin, pinDefined := os.LookupEnv("HK_PIN")
 	if !pinDefined {
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Price provider with hourly prices of 1, and 0.1 in the cheap hours
+type cheapHoursProvider struct {
+	cheap map[int]bool
+	err   error
+}
+
+func (p cheapHoursProvider) FetchPrices(from, to time.Time, area string) ([]PricePoint, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	var points []PricePoint
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		price := 1.0
+		if p.cheap[t.In(priceLocation).Hour()] {
+			price = 0.1
+		}
+		points = append(points, PricePoint{Start: t, End: t.Add(time.Hour), Price: price, Currency: "DKK"})
+	}
+	return points, nil
+}
+
+// Controller that can not be read
+type unreachableController struct{}
+
+var errUnreachable = errors.New("no route to host")
+
+func (unreachableController) FetchReadings() (*nilan.Readings, error) { return nil, errUnreachable }
+func (unreachableController) FetchSettings() (*nilan.Settings, error) { return nil, errUnreachable }
+func (unreachableController) SendSettings(nilan.Settings) error       { return errUnreachable }
+
+// Set the settings of the save mode for a test and put them back after it
+func setSaveModeSettings(t *testing.T, sim *Simulator) {
+	saved := []func(){}
+	keep := func(restore func()) { saved = append(saved, restore) }
+	c, on, hours, must, stop, res := clock, isAutoSavePowerMode, runHours, mustHeatTemperatureDifference, stopHeatTemperatureDifference, priceResolution
+	keep(func() {
+		clock, isAutoSavePowerMode, runHours, mustHeatTemperatureDifference, stopHeatTemperatureDifference, priceResolution = c, on, hours, must, stop, res
+	})
+	model, blocks, learner, deadlines := useTankModel, heatInBlocks, tankLearner, comfortDeadlines
+	keep(func() { useTankModel, heatInBlocks, tankLearner, comfortDeadlines = model, blocks, learner, deadlines })
+	heating, ventilation := useHeatingOptimizer, useVentilationOptimizer
+	keep(func() { useHeatingOptimizer, useVentilationOptimizer = heating, ventilation })
+	t.Cleanup(func() {
+		for _, restore := range saved {
+			restore()
+		}
+	})
+
+	clock, isAutoSavePowerMode, runHours, mustHeatTemperatureDifference, stopHeatTemperatureDifference, priceResolution = sim, true, 3, 10, 2, time.Hour
+	useTankModel, heatInBlocks, tankLearner, comfortDeadlines = false, false, nil, nil
+	useHeatingOptimizer, useVentilationOptimizer = false, false
+}
+
+// Return a save mode driving c with fresh controllers and the prices of provider
+func newTestSaveMode(t *testing.T, acc *Nilan, c Controller, provider PriceProvider) *saveMode {
+	planner, err := OpenRollingPlanner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := &CycleGuard{MinOn: 15 * time.Minute, MinOff: 15 * time.Minute, MaxStarts: 3}
+	return &saveMode{
+		acc:         acc,
+		c:           c,
+		scheduler:   &PriceScheduler{Provider: provider, Area: "DK2", PublishHour: 13, MinRetry: time.Hour, MaxRetry: time.Hour},
+		planner:     planner,
+		dhw:         &DHWController{Guard: guard, Override: time.Hour},
+		guard:       guard,
+		heating:     &HeatingController{},
+		ventilation: &VentilationController{LowSpeed: 101},
+		runTime:     3 * time.Hour,
+	}
+}
+
+func TestSaveModeTickSwitchesDHW(t *testing.T) {
+	sim := NewSimulator(time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation), 0)
+	setSaveModeSettings(t, sim)
+	acc := NewNilan(accessory.Info{Name: "Nilan"}, sim)
+	m := newTestSaveMode(t, acc, sim, cheapHoursProvider{cheap: map[int]bool{13: true, 14: true, 15: true}})
+
+	for _, step := range []struct {
+		advance time.Duration
+		state   DHWState
+		paused  bool
+	}{
+		// The tank is 2 C below target outside the cheap hours
+		{0, DHWIdle, true},
+		{30 * time.Minute, DHWIdle, true},
+		// Heated in the cheap hours, waking up at every slot like autoConfigure
+		{30 * time.Minute, DHWScheduledHeating, false},
+		{time.Hour, DHWScheduledHeating, false},
+		{time.Hour, DHWScheduledHeating, false},
+		{time.Hour, DHWIdle, true},
+	} {
+		sim.Advance(step.advance)
+		now := sim.Now()
+		if err := m.tick(now); err != nil {
+			t.Fatal(err)
+		}
+		s, _ := sim.FetchSettings()
+		if m.dhw.State() != step.state || *s.DHWProductionPaused != step.paused {
+			t.Fatalf("%s: got %s paused %t, want %s paused %t", now.Format("15:04"), m.dhw.State(), *s.DHWProductionPaused, step.state, step.paused)
+		}
+	}
+	if got := acc.ElectricPrice.StatusFault.GetValue(); got != characteristic.StatusFaultNoFault {
+		t.Errorf("electric price fault: got %d, want no fault", got)
+	}
+}
+
+func TestSaveModeFaults(t *testing.T) {
+	sim := NewSimulator(time.Date(2026, 10, 16, 12, 0, 0, 0, priceLocation), 0)
+	setSaveModeSettings(t, sim)
+	acc := NewNilan(accessory.Info{Name: "Nilan"}, unreachableController{})
+	m := newTestSaveMode(t, acc, unreachableController{}, cheapHoursProvider{err: &PriceError{Kind: ErrPriceNetwork, Err: errUnreachable}})
+
+	// Neither the machine nor the prices can be had
+	for i := 1; i <= 2; i++ {
+		if err := m.tick(sim.Now()); !errors.Is(err, errUnreachable) {
+			t.Fatalf("tick %d: got %v, want %v", i, err, errUnreachable)
+		}
+		if m.failures != i {
+			t.Fatalf("tick %d: got %d failures in a row, want %d", i, m.failures, i)
+		}
+	}
+	if got := acc.ElectricPrice.StatusFault.GetValue(); got != characteristic.StatusFaultGeneralFault {
+		t.Errorf("electric price fault on fallback prices: got %d, want a fault", got)
+	}
+	if err := updateReadings(acc); !errors.Is(err, errUnreachable) {
+		t.Fatalf("readings: got %v, want %v", err, errUnreachable)
+	}
+	checkDeviceFaults(t, acc, characteristic.StatusFaultGeneralFault)
+
+	// The machine can be read again
+	acc.Controller, m.c = sim, sim
+	if err := updateReadings(acc); err != nil {
+		t.Fatal(err)
+	}
+	checkDeviceFaults(t, acc, characteristic.StatusFaultNoFault)
+	if got := acc.HotWater.CurrentTemperature.GetValue(); got != 48 {
+		t.Errorf("hot water temperature: got %.1f, want 48", got)
+	}
+	if err := m.tick(sim.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if m.failures != 0 {
+		t.Errorf("got %d failures in a row after reading the machine, want 0", m.failures)
+	}
+}
+
+// Check that every service of the machine has the fault status want
+func checkDeviceFaults(t *testing.T, acc *Nilan, want int) {
+	t.Helper()
+	if len(acc.DeviceFaults) == 0 {
+		t.Fatal("no device fault characteristics")
+	}
+	for i, f := range acc.DeviceFaults {
+		if got := f.GetValue(); got != want {
+			t.Errorf("device fault %d: got %d, want %d", i, got, want)
+		}
+	}
+}
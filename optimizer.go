@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Return the heating slots covering d: the cheapest single slots, or with useBlocks the
+// cheapest runs of contiguous slots
+func selectHeatingSlots(points []PricePoint, d time.Duration, useBlocks bool, maxBlocks int, minBlock time.Duration) []PricePoint {
+	if useBlocks {
+		if blocks := cheapestBlocks(points, d, maxBlocks, minBlock); blocks != nil {
+			return blocks
+		}
+		log.Printf("No %d blocks of at least %v fit the prices, using the cheapest hours", maxBlocks, minBlock)
+	}
+	return lowestPricePoints(points, d)
+}
+
+// Return the cheapest slots covering d as at most maxBlocks runs of contiguous slots, each at
+// least minBlock long, or nil if they do not fit. Points must be ordered and of equal length.
+func cheapestBlocks(points []PricePoint, d time.Duration, maxBlocks int, minBlock time.Duration) []PricePoint {
+	if len(points) == 0 || d <= 0 {
+		return nil
+	}
+	if maxBlocks < 1 {
+		maxBlocks = 1
+	}
+	res := points[0].Duration()
+	need := int((d + res - 1) / res)
+	minLen := int((minBlock + res - 1) / res)
+	if minLen < 1 {
+		minLen = 1
+	}
+	if minLen > need {
+		minLen = need
+	}
+	if need > len(points) {
+		return nil
+	}
+
+	// run[i] is the number of contiguous slots ending with slot i-1
+	n := len(points)
+	prefix := make([]float64, n+1)
+	run := make([]int, n+1)
+	for i, p := range points {
+		prefix[i+1] = prefix[i] + p.Price
+		run[i+1] = 1
+		if i > 0 && points[i-1].End.Equal(p.Start) {
+			run[i+1] = run[i] + 1
+		}
+	}
+
+	// cost[k][i][m] is the cheapest way to take m slots in at most k blocks among the first i slots
+	inf := math.Inf(1)
+	// choice leads back to the state cost came from, ending a block starting at i if block is set
+	type choice struct {
+		block bool
+		i, k  int
+	}
+	cost := make([][][]float64, maxBlocks+1)
+	from := make([][][]choice, maxBlocks+1)
+	for k := range cost {
+		cost[k] = make([][]float64, n+1)
+		from[k] = make([][]choice, n+1)
+		for i := range cost[k] {
+			cost[k][i] = make([]float64, need+1)
+			from[k][i] = make([]choice, need+1)
+			for m := range cost[k][i] {
+				cost[k][i][m] = inf
+			}
+			cost[k][i][0] = 0
+		}
+	}
+	for k := 1; k <= maxBlocks; k++ {
+		for i := 1; i <= n; i++ {
+			for m := 1; m <= need; m++ {
+				// slot i-1 not taken
+				cost[k][i][m] = cost[k][i-1][m]
+				from[k][i][m] = choice{i: i - 1, k: k}
+				if cost[k-1][i][m] < cost[k][i][m] {
+					cost[k][i][m] = cost[k-1][i][m]
+					from[k][i][m] = choice{i: i, k: k - 1}
+				}
+				// a block of length l ending with slot i-1
+				for l := minLen; l <= m && l <= run[i]; l++ {
+					c := cost[k-1][i-l][m-l] + prefix[i] - prefix[i-l]
+					if c < cost[k][i][m] {
+						cost[k][i][m] = c
+						from[k][i][m] = choice{block: true, i: i - l, k: k - 1}
+					}
+				}
+			}
+		}
+	}
+	if math.IsInf(cost[maxBlocks][n][need], 1) {
+		return nil
+	}
+
+	var blocks []PricePoint
+	for k, i, m := maxBlocks, n, need; m > 0; {
+		c := from[k][i][m]
+		if c.block {
+			blocks = append(append([]PricePoint(nil), points[c.i:i]...), blocks...)
+			m -= i - c.i
+		}
+		i, k = c.i, c.k
+	}
+	return blocks
+}
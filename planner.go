@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RollingPlanner plans the heating over all known future slots with a run time per day. Slots
+// of the plan that have started are committed: they stay in the plan and count towards the run
+// time of their day, also after a restart when Path is set.
+type RollingPlanner struct {
+	Path string
+
+	mu        sync.Mutex
+	committed []PricePoint
+}
+
+// OpenRollingPlanner reads the committed slots from path. An empty path or a missing file
+// starts without committed slots.
+func OpenRollingPlanner(path string) (*RollingPlanner, error) {
+	rp := &RollingPlanner{Path: path}
+	if path == "" {
+		return rp, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rp, nil
+	}
+	if err != nil {
+		return rp, err
+	}
+	if err := json.Unmarshal(data, &rp.committed); err != nil {
+		return rp, err
+	}
+	for i := range rp.committed {
+		rp.committed[i].Start = rp.committed[i].Start.In(priceLocation)
+		rp.committed[i].End = rp.committed[i].End.In(priceLocation)
+	}
+	return rp, nil
+}
+
+// Plan returns the heating slots at now. For every day of prices the committed slots are kept
+// and the rest of runTime(day) is chosen by selectSlots among the slots not over yet.
+func (rp *RollingPlanner) Plan(prices []PricePoint, now time.Time, runTime func(day time.Time) time.Duration, selectSlots func(points []PricePoint, d time.Duration) []PricePoint) []PricePoint {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	committed := make(map[int64]bool)
+	for _, p := range rp.committed {
+		committed[p.Start.UnixNano()] = true
+	}
+
+	days := make(map[time.Time][]PricePoint)
+	var order []time.Time
+	for _, p := range prices {
+		day := planningWindowStart(p.Start)
+		if _, ok := days[day]; !ok {
+			order = append(order, day)
+		}
+		days[day] = append(days[day], p)
+	}
+
+	plan := append([]PricePoint(nil), rp.committed...)
+	for _, day := range order {
+		var candidates []PricePoint
+		for _, p := range days[day] {
+			if p.End.After(now) && !committed[p.Start.UnixNano()] {
+				candidates = append(candidates, p)
+			}
+		}
+		done := heatingBetween(rp.committed, day, day.AddDate(0, 0, 1))
+		if remaining := runTime(day) - done; remaining > 0 {
+			plan = append(plan, selectSlots(candidates, remaining)...)
+		}
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Start.Before(plan[j].Start)
+	})
+	return plan
+}
+
+// Commit the slots of plan that have started at now, and forget committed slots older than the
+// day before. The committed slots are written to Path if set.
+func (rp *RollingPlanner) Commit(plan []PricePoint, now time.Time) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	committed := make(map[int64]bool)
+	for _, p := range rp.committed {
+		committed[p.Start.UnixNano()] = true
+	}
+	changed := false
+	for _, p := range plan {
+		if !p.Start.After(now) && !committed[p.Start.UnixNano()] {
+			rp.committed = append(rp.committed, p)
+			committed[p.Start.UnixNano()] = true
+			changed = true
+		}
+	}
+	oldest := planningWindowStart(now).AddDate(0, 0, -1)
+	kept := rp.committed[:0]
+	for _, p := range rp.committed {
+		if p.End.After(oldest) {
+			kept = append(kept, p)
+		} else {
+			changed = true
+		}
+	}
+	rp.committed = kept
+
+	if !changed || rp.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(rp.committed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rp.Path, data, 0666)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// priceCacheDays is how many days of prices the cache keeps
+const priceCacheDays = 7
+
+// PriceCache keeps fetched prices in a file keyed by date and price area, so they survive a
+// restart and can be used when fetching fails
+type PriceCache struct {
+	Path string
+
+	mu   sync.Mutex
+	days map[string][]PricePoint
+}
+
+// OpenPriceCache reads the cache file at path. A missing file gives an empty cache.
+func OpenPriceCache(path string) (*PriceCache, error) {
+	c := &PriceCache{Path: path, days: make(map[string][]PricePoint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.days); err != nil {
+		return c, err
+	}
+	for _, day := range c.days {
+		for i := range day {
+			day[i].Start = day[i].Start.In(priceLocation)
+			day[i].End = day[i].End.In(priceLocation)
+		}
+	}
+	return c, nil
+}
+
+// Cache key of the day of t in area
+func priceCacheKey(t time.Time, area string) string {
+	return t.In(priceLocation).Format("2006-01-02") + "/" + area
+}
+
+// Load returns the cached prices of area starting in [from, to)
+func (c *PriceCache) Load(from, to time.Time, area string) []PricePoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var points []PricePoint
+	for day := from.In(priceLocation); day.Before(to.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+		for _, p := range c.days[priceCacheKey(day, area)] {
+			if !p.Start.Before(from) && p.Start.Before(to) {
+				points = append(points, p)
+			}
+		}
+	}
+	return mergePricePoints(nil, points)
+}
+
+// Store adds the prices of area to the cache and writes the cache file. Days already cached are
+// only replaced by a series that is at least as long.
+func (c *PriceCache) Store(area string, points []PricePoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byDay := make(map[string][]PricePoint)
+	for _, p := range points {
+		if p.Estimated {
+			continue
+		}
+		key := priceCacheKey(p.Start, area)
+		byDay[key] = append(byDay[key], p)
+	}
+	for key, day := range byDay {
+		if len(day) >= len(c.days[key]) {
+			c.days[key] = day
+		}
+	}
+
+	oldest := time.Now().In(priceLocation).AddDate(0, 0, -priceCacheDays).Format("2006-01-02")
+	for key := range c.days {
+		if key[:len("2006-01-02")] < oldest {
+			delete(c.days, key)
+		}
+	}
+
+	data, err := json.Marshal(c.days)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, data, 0666)
+}
+
+// CachedProvider serves prices from a PriceCache and only asks Provider when the cache does not
+// cover the requested range
+type CachedProvider struct {
+	Provider PriceProvider
+	Cache    *PriceCache
+}
+
+// FetchPrices from the cache or the provider. If the provider fails, the cached prices are
+// returned along with the error.
+func (c *CachedProvider) FetchPrices(from, to time.Time, area string) ([]PricePoint, error) {
+	cached := c.Cache.Load(from, to, area)
+	if coversRange(cached, from, to) {
+		return cached, nil
+	}
+
+	points, err := c.Provider.FetchPrices(from, to, area)
+	if err != nil {
+		if len(cached) > 0 {
+			log.Printf("Fetching prices failed, using %d cached prices: %v", len(cached), err)
+		}
+		return cached, err
+	}
+	if err := c.Cache.Store(area, points); err != nil {
+		log.Printf("error writing price cache: %v", err)
+	}
+	return mergePricePoints(cached, points), nil
+}
+
+// Tells if the slots of points cover [from, to) without gaps
+func coversRange(points []PricePoint, from, to time.Time) bool {
+	t := from
+	for t.Before(to) {
+		p, ok := pricePointAt(points, t)
+		if !ok {
+			return false
+		}
+		t = p.End
+	}
+	return true
+}
+
+// Return the price points of both slices ordered by start time, preferring newer on equal start
+func mergePricePoints(older, newer []PricePoint) []PricePoint {
+	byStart := make(map[int64]PricePoint)
+	for _, p := range older {
+		byStart[p.Start.UnixNano()] = p
+	}
+	for _, p := range newer {
+		byStart[p.Start.UnixNano()] = p
+	}
+	merged := make([]PricePoint, 0, len(byStart))
+	for _, p := range byStart {
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Start.Before(merged[j].Start)
+	})
+	return merged
+}
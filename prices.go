@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// PricePoint is the electricity price of one time slot
+type PricePoint struct {
+	Start    time.Time
+	End      time.Time
+	Price    float64
+	Currency string
+	// Estimated is set when the price is not published but comes from the fallback policy
+	Estimated bool `json:",omitempty"`
+}
+
+// PriceProvider fetches electricity prices for a price area
+type PriceProvider interface {
+	// FetchPrices returns the prices of all slots starting in [from, to) ordered by start time.
+	// Slots have the resolution of the source, e.g. 15 or 60 minutes. Errors are *PriceError,
+	// and prices may be returned along with an error when only some could be had.
+	FetchPrices(from, to time.Time, area string) ([]PricePoint, error)
+}
+
+// Price areas of the Nordic day-ahead market
+const (
+	PriceAreaWest = "DK1"
+	PriceAreaEast = "DK2"
+)
+
+// priceLocation is the time zone of the Danish price areas
+var priceLocation = loadPriceLocation()
+
+func loadPriceLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Contains tells if t is inside the slot of the price point
+func (p PricePoint) Contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// Duration of the slot of the price point
+func (p PricePoint) Duration() time.Duration {
+	return p.End.Sub(p.Start)
+}
+
+// Return the cheapest price points covering at least d, ordered by start time
+func lowestPricePoints(points []PricePoint, d time.Duration) []PricePoint {
+	sorted := make([]PricePoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Price < sorted[j].Price
+	})
+	n := 0
+	for covered := time.Duration(0); n < len(sorted) && covered < d; n++ {
+		covered += sorted[n].Duration()
+	}
+	lowest := sorted[:n]
+	sort.Slice(lowest, func(i, j int) bool {
+		return lowest[i].Start.Before(lowest[j].Start)
+	})
+	return lowest
+}
+
+// Return the most expensive price points covering at least d, ordered by start time
+func highestPricePoints(points []PricePoint, d time.Duration) []PricePoint {
+	negated := make([]PricePoint, len(points))
+	for i, p := range points {
+		p.Price = -p.Price
+		negated[i] = p
+	}
+	highest := lowestPricePoints(negated, d)
+	for i := range highest {
+		highest[i].Price = -highest[i].Price
+	}
+	return highest
+}
+
+// Return the price points as slots of length res. Longer points are split and shorter
+// points are averaged weighted by their duration.
+func resamplePricePoints(points []PricePoint, res time.Duration) []PricePoint {
+	if res <= 0 {
+		return points
+	}
+	type bucket struct {
+		sum       float64
+		covered   time.Duration
+		currency  string
+		estimated bool
+	}
+	buckets := make(map[time.Time]*bucket)
+	var starts []time.Time
+	for _, p := range points {
+		for start := p.Start; start.Before(p.End); {
+			slot := start.Truncate(res)
+			end := slot.Add(res)
+			if p.End.Before(end) {
+				end = p.End
+			}
+			b, ok := buckets[slot]
+			if !ok {
+				b = &bucket{currency: p.Currency}
+				buckets[slot] = b
+				starts = append(starts, slot)
+			}
+			b.sum += p.Price * float64(end.Sub(start))
+			b.estimated = b.estimated || p.Estimated
+			b.covered += end.Sub(start)
+			start = end
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Before(starts[j])
+	})
+	slots := make([]PricePoint, 0, len(starts))
+	for _, start := range starts {
+		b := buckets[start]
+		slots = append(slots, PricePoint{Start: start, End: start.Add(res), Price: b.sum / float64(b.covered), Currency: b.currency, Estimated: b.estimated})
+	}
+	return slots
+}
+
+// Return the first slot boundary of points after t, or the zero time if there is none
+func nextSlotBoundary(points []PricePoint, t time.Time) time.Time {
+	var next time.Time
+	for _, p := range points {
+		for _, b := range []time.Time{p.Start, p.End} {
+			if b.After(t) && (next.IsZero() || b.Before(next)) {
+				next = b
+			}
+		}
+	}
+	return next
+}
+
+// Return the start of the planning window, which is the day of now as prices are published per day
+func planningWindowStart(now time.Time) time.Time {
+	now = now.In(priceLocation)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// Tells if t is in one of the slots of points
+func inPricePoints(points []PricePoint, t time.Time) bool {
+	_, ok := pricePointAt(points, t)
+	return ok
+}
+
+// Fill the gaps in points between from and to following the fallback policy:
+//
+//  1. the price at the same time the day before, when history has it
+//  2. otherwise the hours [nightFrom, nightTo) are cheap and all other hours expensive
+//
+// The filled in price points are marked as estimated.
+func fillMissingPrices(points, history []PricePoint, from, to time.Time, nightFrom, nightTo int) []PricePoint {
+	filled := append([]PricePoint(nil), points...)
+	for t := from; t.Before(to); {
+		if p, ok := pricePointAt(points, t); ok {
+			t = p.End
+			continue
+		}
+
+		var fill PricePoint
+		if y, ok := pricePointAt(history, t.AddDate(0, 0, -1)); ok && !y.Estimated {
+			fill = PricePoint{Start: t, End: y.End.AddDate(0, 0, 1), Price: y.Price, Currency: y.Currency, Estimated: true}
+		} else {
+			price := 1.0
+			if h := t.In(priceLocation).Hour(); h >= nightFrom && h < nightTo {
+				price = 0
+			}
+			fill = PricePoint{Start: t, End: t.Truncate(time.Hour).Add(time.Hour), Price: price, Currency: "DKK", Estimated: true}
+		}
+		if next := nextSlotBoundary(points, t); !next.IsZero() && next.Before(fill.End) {
+			fill.End = next
+		}
+		if fill.End.After(to) {
+			fill.End = to
+		}
+		if !fill.End.After(t) {
+			fill.End = t.Add(time.Hour)
+		}
+		filled = append(filled, fill)
+		t = fill.End
+	}
+	sort.Slice(filled, func(i, j int) bool {
+		return filled[i].Start.Before(filled[j].Start)
+	})
+	return filled
+}
+
+// Return the price provider configured by name, defaulting to the Andel Energi scraper
+func newPriceProvider(name string, dataset string) PriceProvider {
+	switch name {
+	case "energidataservice":
+		return NewEnergiDataServiceProvider(dataset)
+	default:
+		return NewAndelProvider()
+	}
+}
+
+// Return the price point whose slot contains t
+func pricePointAt(points []PricePoint, t time.Time) (PricePoint, bool) {
+	for _, p := range points {
+		if p.Contains(t) {
+			return p, true
+		}
+	}
+	return PricePoint{}, false
+}
+
+// Kinds of PriceError
+var (
+	ErrPriceNetwork     = errors.New("network error")
+	ErrPriceParse       = errors.New("parse error")
+	ErrPricesIncomplete = errors.New("prices incomplete")
+	ErrPricesStale      = errors.New("prices stale")
+)
+
+// PriceError is an error getting prices. errors.Is matches its Kind.
+type PriceError struct {
+	// Kind is one of ErrPriceNetwork, ErrPriceParse, ErrPricesIncomplete or ErrPricesStale
+	Kind error
+	Err  error
+}
+
+func (e *PriceError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *PriceError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells if target is the kind of the error
+func (e *PriceError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Return the start of the first estimated slot of points from t
+func firstMissingPrice(points []PricePoint, t time.Time) time.Time {
+	for _, p := range points {
+		if p.Estimated && !p.Start.Before(t) {
+			return p.Start
+		}
+	}
+	return t
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// PricePlan holds the prices of one planning window
+type PricePlan struct {
+	Start  time.Time
+	Prices []PricePoint
+	// Final is set when all prices of the window are published, so the plan will not change
+	Final bool
+	// Err is the error of the last fetch, the prices then partly come from the fallback policy
+	Err error
+}
+
+// PriceScheduler fetches the prices of the current and the next planning window. The next
+// day's prices are published around PublishHour, from then on they are polled with a backoff
+// between MinRetry and MaxRetry until they are all there.
+type PriceScheduler struct {
+	Provider    PriceProvider
+	Area        string
+	PublishHour int
+	MinRetry    time.Duration
+	MaxRetry    time.Duration
+
+	mu        sync.Mutex
+	current   PricePlan
+	next      PricePlan
+	nextFetch time.Time
+	retry     time.Duration
+}
+
+// Update fetches prices if due at now. It is called on every tick of autoConfigure.
+func (s *PriceScheduler) Update(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := planningWindowStart(now)
+	if !s.current.Start.Equal(start) {
+		if s.next.Start.Equal(start) {
+			s.current = s.next
+		} else {
+			s.current = PricePlan{Start: start}
+		}
+		s.next = PricePlan{Start: start.AddDate(0, 0, 1)}
+		s.nextFetch = time.Time{}
+		s.retry = 0
+	}
+	if now.Before(s.nextFetch) {
+		return
+	}
+
+	plan := &s.current
+	if s.current.Final {
+		if s.next.Final {
+			return
+		}
+		// The prices of the next day come out the day before
+		n := s.next.Start.AddDate(0, 0, -1)
+		publish := time.Date(n.Year(), n.Month(), n.Day(), s.PublishHour, 0, 0, 0, n.Location())
+		if now.Before(publish) {
+			s.nextFetch = publish
+			return
+		}
+		plan = &s.next
+	}
+
+	prices, err := fetchWindowPrices(s.Provider, s.Area, plan.Start)
+	plan.Prices = prices
+	plan.Err = err
+	plan.Final = err == nil && !hasEstimatedPrices(prices)
+	if plan.Final {
+		log.Printf("Prices in %s from %s are final", s.Area, plan.Start.Format("2006-01-02 15:04"))
+		s.retry = 0
+		s.nextFetch = time.Time{}
+		return
+	}
+
+	s.retry *= 2
+	if s.retry < s.MinRetry {
+		s.retry = s.MinRetry
+	}
+	if s.retry > s.MaxRetry {
+		s.retry = s.MaxRetry
+	}
+	s.nextFetch = now.Add(s.retry)
+	log.Printf("Prices in %s from %s are not complete, trying again at %s: %v", s.Area, plan.Start.Format("2006-01-02 15:04"), s.nextFetch.Format("15:04"), err)
+}
+
+// Current returns the plan of the planning window running now
+func (s *PriceScheduler) Current() PricePlan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Known returns the prices of the current window and, once they are final, of the next window
+func (s *PriceScheduler) Known() []PricePoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := append([]PricePoint(nil), s.current.Prices...)
+	if s.next.Final {
+		known = append(known, s.next.Prices...)
+	}
+	return known
+}
+
+// TomorrowFinal tells if the prices of the next planning window are all published
+func (s *PriceScheduler) TomorrowFinal() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next.Final
+}
+
+// Tells if some of the prices come from the fallback policy
+func hasEstimatedPrices(points []PricePoint) bool {
+	for _, p := range points {
+		if p.Estimated {
+			return true
+		}
+	}
+	return false
+}
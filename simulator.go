@@ -0,0 +1,261 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Simulator is a simulated Nilan CTS700 for running without a machine. It models the DHW tank,
+// the room, the humidity and the pause flags over simulated time, which runs Speed times as
+// fast as the wall clock.
+//
+// The tank heats at 10 C/h while DHW production is on and below its setpoint, and loses 0.5
+// C/h. Showers at 07:00 and 19:00 draw hot water and raise the humidity for 10 minutes. The
+// room is heated from the supply setpoint while central heating is on and below the desired
+// room temperature, and loses heat to the outdoor temperature following a daily curve.
+type Simulator struct {
+	Speed float64
+
+	mu   sync.Mutex
+	now  time.Time
+	wall time.Time
+
+	tank        float64
+	room        float64
+	supply      float64
+	humidity    float64
+	avgHumidity float64
+
+	fanSpeed          nilan.FanSpeed
+	desiredRoom       int
+	desiredDHW        int
+	dhwPaused         bool
+	dhwPauseDuration  int
+	dhwPauseUntil     time.Time
+	chPaused          bool
+	chPauseDuration   int
+	chPauseUntil      time.Time
+	chOn              bool
+	ventilationMode   int
+	ventilationPaused bool
+	setpointSupply    int
+}
+
+// NewSimulator returns a simulator starting at now with a warm house and a hot tank
+func NewSimulator(now time.Time, speed float64) *Simulator {
+	return &Simulator{
+		Speed:           speed,
+		now:             now,
+		wall:            time.Now(),
+		tank:            48,
+		room:            21,
+		supply:          21,
+		humidity:        45,
+		avgHumidity:     45,
+		fanSpeed:        nilan.FanSpeedNormal,
+		desiredRoom:     210,
+		desiredDHW:      500,
+		chOn:            true,
+		ventilationMode: 0,
+		setpointSupply:  350,
+	}
+}
+
+// Now returns the simulated time
+func (sim *Simulator) Now() time.Time {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sync()
+	return sim.now
+}
+
+// Sleep for d of simulated time
+func (sim *Simulator) Sleep(d time.Duration) {
+	time.Sleep(time.Duration(float64(d) / sim.Speed))
+}
+
+// Advance the simulated time by d
+func (sim *Simulator) Advance(d time.Duration) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.advance(d)
+}
+
+// FetchReadings of the simulated machine
+func (sim *Simulator) FetchReadings() (*nilan.Readings, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sync()
+	return &nilan.Readings{
+		RoomTemperature:          int(math.Round(sim.room * 10)),
+		OutdoorTemperature:       int(math.Round(sim.outdoor() * 10)),
+		AverageHumidity:          int(math.Round(sim.avgHumidity)),
+		ActualHumidity:           int(math.Round(sim.humidity)),
+		DHWTankTopTemperature:    int(math.Round(sim.tank * 10)),
+		DHWTankBottomTemperature: int(math.Round((sim.tank - 5) * 10)),
+		SupplyFlowTemperature:    int(math.Round(sim.supply * 10)),
+	}, nil
+}
+
+// FetchSettings of the simulated machine
+func (sim *Simulator) FetchSettings() (*nilan.Settings, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sync()
+	fanSpeed := sim.fanSpeed
+	desiredRoom := sim.desiredRoom
+	desiredDHW := sim.desiredDHW
+	dhwPaused := sim.dhwPaused
+	dhwPauseDuration := sim.dhwPauseDuration
+	chPaused := sim.chPaused
+	chPauseDuration := sim.chPauseDuration
+	chOn := sim.chOn
+	ventilationMode := sim.ventilationMode
+	ventilationPaused := sim.ventilationPaused
+	setpointSupply := sim.setpointSupply
+	return &nilan.Settings{
+		FanSpeed:                    &fanSpeed,
+		DesiredRoomTemperature:      &desiredRoom,
+		DesiredDHWTemperature:       &desiredDHW,
+		DHWProductionPaused:         &dhwPaused,
+		DHWProductionPauseDuration:  &dhwPauseDuration,
+		CentralHeatingPaused:        &chPaused,
+		CentralHeatingPauseDuration: &chPauseDuration,
+		CentralHeatingIsOn:          &chOn,
+		VentilationMode:             &ventilationMode,
+		VentilationOnPause:          &ventilationPaused,
+		SetpointSupplyTemperature:   &setpointSupply,
+	}, nil
+}
+
+// SendSettings to the simulated machine, <nil> values are ignored
+func (sim *Simulator) SendSettings(settings nilan.Settings) error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.sync()
+	if settings.FanSpeed != nil {
+		sim.fanSpeed = *settings.FanSpeed
+	}
+	if settings.DesiredRoomTemperature != nil {
+		sim.desiredRoom = *settings.DesiredRoomTemperature
+	}
+	if settings.DesiredDHWTemperature != nil {
+		sim.desiredDHW = *settings.DesiredDHWTemperature
+	}
+	// Setting the pause or its duration starts the pause over, Modbus writes them one at a time
+	if settings.DHWProductionPauseDuration != nil {
+		sim.dhwPauseDuration = *settings.DHWProductionPauseDuration
+	}
+	if settings.DHWProductionPaused != nil {
+		sim.dhwPaused = *settings.DHWProductionPaused
+	}
+	if settings.DHWProductionPaused != nil || settings.DHWProductionPauseDuration != nil {
+		sim.dhwPauseUntil = pauseUntil(sim.now, sim.dhwPaused, sim.dhwPauseDuration)
+	}
+	if settings.CentralHeatingPauseDuration != nil {
+		sim.chPauseDuration = *settings.CentralHeatingPauseDuration
+	}
+	if settings.CentralHeatingPaused != nil {
+		sim.chPaused = *settings.CentralHeatingPaused
+	}
+	if settings.CentralHeatingPaused != nil || settings.CentralHeatingPauseDuration != nil {
+		sim.chPauseUntil = pauseUntil(sim.now, sim.chPaused, sim.chPauseDuration)
+	}
+	if settings.CentralHeatingIsOn != nil {
+		sim.chOn = *settings.CentralHeatingIsOn
+	}
+	if settings.VentilationMode != nil {
+		sim.ventilationMode = *settings.VentilationMode
+	}
+	if settings.VentilationOnPause != nil {
+		sim.ventilationPaused = *settings.VentilationOnPause
+	}
+	if settings.SetpointSupplyTemperature != nil {
+		sim.setpointSupply = *settings.SetpointSupplyTemperature
+	}
+	return nil
+}
+
+// Return when a pause of duration minutes from now ends, or the zero time if it does not
+func pauseUntil(now time.Time, paused bool, duration int) time.Time {
+	if !paused || duration <= 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(duration) * time.Minute)
+}
+
+// Advance the simulated time by the wall time passed since the last call
+func (sim *Simulator) sync() {
+	wall := time.Now()
+	sim.advance(time.Duration(float64(wall.Sub(sim.wall)) * sim.Speed))
+	sim.wall = wall
+}
+
+// Advance the simulated time by d in steps of at most a minute
+func (sim *Simulator) advance(d time.Duration) {
+	for d > 0 {
+		step := d
+		if step > time.Minute {
+			step = time.Minute
+		}
+		sim.step(step)
+		d -= step
+	}
+}
+
+func (sim *Simulator) step(d time.Duration) {
+	sim.now = sim.now.Add(d)
+	h := d.Hours()
+
+	if sim.dhwPaused && !sim.dhwPauseUntil.IsZero() && !sim.now.Before(sim.dhwPauseUntil) {
+		sim.dhwPaused = false
+	}
+	if sim.chPaused && !sim.chPauseUntil.IsZero() && !sim.now.Before(sim.chPauseUntil) {
+		sim.chPaused = false
+	}
+
+	// DHW tank
+	if !sim.dhwPaused && sim.tank < float64(sim.desiredDHW)/10 {
+		sim.tank += 10 * h
+	}
+	sim.tank -= 0.5 * h
+	showering := sim.showering()
+	if showering {
+		sim.tank -= 40 * h
+		sim.humidity += 120 * h
+	}
+
+	// Room and central heating
+	outdoor := sim.outdoor()
+	if sim.chOn && !sim.chPaused && sim.room < float64(sim.desiredRoom)/10 {
+		sim.supply = float64(sim.setpointSupply) / 10
+	} else {
+		sim.supply += (sim.room - sim.supply) * math.Min(1, 2*h)
+	}
+	sim.room += (0.06*(sim.supply-sim.room) - 0.03*(sim.room-outdoor)) * h
+
+	// Humidity is ventilated out faster at a higher fan speed
+	rate := 0.5 * (float64(sim.fanSpeed) - 100)
+	if sim.ventilationPaused {
+		rate = 0.05
+	}
+	sim.humidity -= (sim.humidity - 45) * math.Min(1, rate*h)
+	sim.humidity = math.Min(sim.humidity, 100)
+	sim.avgHumidity += (sim.humidity - sim.avgHumidity) * math.Min(1, h)
+}
+
+// Outdoor temperature in C, coldest at 03:00 and warmest at 15:00
+func (sim *Simulator) outdoor() float64 {
+	t := sim.now.In(priceLocation)
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return 7 + 4*math.Sin(2*math.Pi*(hour-9)/24)
+}
+
+// Tells if someone is taking a shower
+func (sim *Simulator) showering() bool {
+	t := sim.now.In(priceLocation)
+	return (t.Hour() == 7 || t.Hour() == 19) && t.Minute() < 10
+}
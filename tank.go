@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrNoHeatRate is returned by the tank model when it does not know how fast the tank heats up
+var ErrNoHeatRate = errors.New("tank heat-up rate is not set, set celiusperhour under [setting] in config.toml")
+
+// TankModel of the DHW tank, temperatures in C
+type TankModel struct {
+	// HeatRate is how fast the tank heats up in C per hour
+	HeatRate float64
+	// StandbyLoss is how fast the tank cools down when not heating in C per hour
+	StandbyLoss float64
+}
+
+// Return the tank model from the config, or with the learned rates at the outdoor temperature
+func currentTankModel(outdoor float64) TankModel {
+	model := TankModel{HeatRate: celiusHours, StandbyLoss: standbyLoss}
+	if tankLearner != nil {
+		model = tankLearner.Model(outdoor, model)
+	}
+	return model
+}
+
+// RunTime returns how long the tank must heat within window to get from actual to desired and
+// make up for the standby loss in the rest of the window, or ErrNoHeatRate without a heat-up rate
+func (m TankModel) RunTime(desired, actual float64, window time.Duration) (time.Duration, error) {
+	if m.HeatRate <= 0 {
+		return 0, ErrNoHeatRate
+	}
+	// h hours of heating and the rest of the window losing heat:
+	// h*HeatRate = desired - actual + (window - h)*StandbyLoss
+	deficit := math.Max(desired-actual, 0)
+	hours := (deficit + window.Hours()*m.StandbyLoss) / (m.HeatRate + m.StandbyLoss)
+	d := time.Duration(hours * float64(time.Hour))
+	if d > window {
+		d = window
+	}
+	return d, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/theherk/viper"
+)
+
+// Tariff turns spot prices into the price paid by the consumer. All amounts are in DKK/kWh
+// excluding VAT.
+type Tariff struct {
+	// Grid holds the time-of-use tariffs of the grid company, e.g. Radius or Cerius
+	Grid []GridTariff `mapstructure:"grid"`
+	// Transmission is the net and system tariff of Energinet
+	Transmission float64 `mapstructure:"transmission"`
+	// Elafgift is the electricity tax
+	Elafgift float64 `mapstructure:"elafgift"`
+	// Supplier is the markup of the electricity supplier
+	Supplier float64 `mapstructure:"supplier"`
+	// VAT as a fraction, e.g. 0.25
+	VAT float64 `mapstructure:"vat"`
+}
+
+// GridTariff is the grid tariff for the hours [From, To) in Months, or in all months if empty.
+// A period with From after To wraps past midnight, e.g. from 21 to 6.
+type GridTariff struct {
+	From   int     `mapstructure:"from"`
+	To     int     `mapstructure:"to"`
+	Months []int   `mapstructure:"months"`
+	Price  float64 `mapstructure:"price"`
+}
+
+// Read the tariff from the [tariff] table of config.toml
+func loadTariff() (Tariff, error) {
+	var t Tariff
+	if err := viper.UnmarshalKey("tariff", &t); err != nil {
+		return t, err
+	}
+	for _, g := range t.Grid {
+		if g.From < 0 || g.From > 23 || g.To < 1 || g.To > 24 || g.From == g.To {
+			return t, fmt.Errorf("grid tariff from %d to %d is not a period of hours", g.From, g.To)
+		}
+	}
+	return t, nil
+}
+
+// GridPrice returns the grid tariff at t
+func (t Tariff) GridPrice(at time.Time) float64 {
+	at = at.In(priceLocation)
+	for _, g := range t.Grid {
+		if !g.contains(at.Hour()) {
+			continue
+		}
+		if len(g.Months) == 0 {
+			return g.Price
+		}
+		for _, m := range g.Months {
+			if time.Month(m) == at.Month() {
+				return g.Price
+			}
+		}
+	}
+	return 0
+}
+
+// Tells if the period of g contains hour
+func (g GridTariff) contains(hour int) bool {
+	if g.From > g.To {
+		return hour >= g.From || hour < g.To
+	}
+	return hour >= g.From && hour < g.To
+}
+
+// Price returns the consumer price of a spot price at t
+func (t Tariff) Price(spot float64, at time.Time) float64 {
+	return (spot + t.GridPrice(at) + t.Transmission + t.Elafgift + t.Supplier) * (1 + t.VAT)
+}
+
+// Apply returns the price points with consumer prices
+func (t Tariff) Apply(points []PricePoint) []PricePoint {
+	consumer := make([]PricePoint, len(points))
+	for i, p := range points {
+		p.Price = t.Price(p.Price, p.Start)
+		consumer[i] = p
+	}
+	return consumer
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theherk/viper"
+)
+
+func TestTariffGridPrice(t *testing.T) {
+	tariff := Tariff{Grid: []GridTariff{
+		{From: 21, To: 6, Price: 0.13},
+		{From: 6, To: 17, Price: 0.39},
+		{From: 17, To: 21, Months: []int{10}, Price: 1.16},
+	}}
+	for _, tt := range []struct {
+		hour  int
+		month time.Month
+		want  float64
+	}{
+		{21, time.October, 0.13},
+		{23, time.October, 0.13},
+		{0, time.October, 0.13},
+		{5, time.October, 0.13},
+		{6, time.October, 0.39},
+		{16, time.October, 0.39},
+		{17, time.October, 1.16},
+		{17, time.November, 0},
+	} {
+		at := time.Date(2026, tt.month, 16, tt.hour, 30, 0, 0, priceLocation)
+		if got := tariff.GridPrice(at); got != tt.want {
+			t.Errorf("%s: got grid price %.2f, want %.2f", at.Format("2006-01-02 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestLoadTariff(t *testing.T) {
+	defer viper.Reset()
+	for _, tt := range []struct {
+		from, to int
+		valid    bool
+	}{
+		{0, 6, true},
+		{21, 24, true},
+		{21, 6, true},
+		{6, 6, false},
+		{-1, 6, false},
+		{21, 25, false},
+		{24, 6, false},
+	} {
+		viper.Reset()
+		viper.SetConfigType("toml")
+		config := fmt.Sprintf("[[tariff.grid]]\nfrom = %d\nto = %d\nprice = 0.13\n", tt.from, tt.to)
+		if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTariff(); (err == nil) != tt.valid {
+			t.Errorf("grid tariff from %d to %d: got error %v, want valid %t", tt.from, tt.to, err, tt.valid)
+		}
+	}
+}
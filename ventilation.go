@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// VentilationInput is what the ventilation controller decides on
+type VentilationInput struct {
+	Now time.Time
+	// Expensive is set in one of the most expensive slots
+	Expensive bool
+	// Humidity is the actual humidity in %
+	Humidity float64
+	// Speed is the fan speed of the machine
+	Speed nilan.FanSpeed
+}
+
+// VentilationController drops the fan to LowSpeed in the most expensive slots and restores the
+// speed set by hand after them. The fan is not lowered while the humidity is at or above
+// HumidityCeiling %, and a speed set by hand in an expensive slot is kept until it is over.
+//
+// With boost on, a rise in humidity of BoostRise % within BoostWindow, like from a shower,
+// runs the fan at BoostSpeed until the humidity is back within BoostSettle % of where it rose
+// from, or for at most BoostMax.
+//
+// The speed from before lowering or boosting is kept in Path if set, so a restart does not take
+// LowSpeed or BoostSpeed as the speed set by hand.
+type VentilationController struct {
+	LowSpeed        nilan.FanSpeed
+	HumidityCeiling float64
+	BoostSpeed      nilan.FanSpeed
+	BoostRise       float64
+	BoostWindow     time.Duration
+	BoostSettle     float64
+	BoostMax        time.Duration
+	Path            string
+
+	mu         sync.Mutex
+	base       nilan.FanSpeed
+	lowered    bool
+	held       bool
+	boost      bool
+	boosting   bool
+	boostStart time.Time
+	boostFrom  float64
+	samples    []humiditySample
+	saved      ventilationState
+}
+
+// ventilationState is the part of the VentilationController kept in its Path
+type ventilationState struct {
+	Base       nilan.FanSpeed `json:"base"`
+	Lowered    bool           `json:"lowered"`
+	Held       bool           `json:"held"`
+	Boosting   bool           `json:"boosting"`
+	BoostStart time.Time      `json:"booststart"`
+	BoostFrom  float64        `json:"boostfrom"`
+}
+
+type humiditySample struct {
+	t        time.Time
+	humidity float64
+}
+
+// OpenVentilationController reads the state of the controller from path. An empty path or a
+// missing file starts with the fan neither lowered nor boosted.
+func OpenVentilationController(path string) (*VentilationController, error) {
+	c := &VentilationController{Path: path}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.saved); err != nil {
+		return c, err
+	}
+	c.base, c.lowered, c.held = c.saved.Base, c.saved.Lowered, c.saved.Held
+	c.boosting, c.boostStart, c.boostFrom = c.saved.Boosting, c.saved.BoostStart, c.saved.BoostFrom
+	return c, nil
+}
+
+// SetBoost turns the humidity boost on or off
+func (c *VentilationController) SetBoost(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.boost = on
+}
+
+// Step returns the fan speed to use
+func (c *VentilationController) Step(in VentilationInput) nilan.FanSpeed {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.save()
+
+	// A speed set by hand ends the boost
+	if c.boosting && in.Speed != c.BoostSpeed {
+		log.Printf("Humidity boost ended, fan speed was set to %d", in.Speed)
+		c.boosting = false
+		c.samples = nil
+	}
+	// A speed set by hand while lowered holds until the expensive slots are over
+	if c.lowered && in.Speed != c.LowSpeed {
+		log.Printf("Fan speed was set to %d, keeping it until the expensive slot is over", in.Speed)
+		c.lowered = false
+		c.held = true
+	}
+	if !in.Expensive {
+		c.held = false
+	}
+	// The speed is the one set by hand unless it is ours
+	if !c.boosting && (!c.lowered || in.Speed != c.LowSpeed) {
+		c.base = in.Speed
+	}
+	c.updateBoost(in)
+
+	lower := !c.boosting && !c.held && in.Expensive && in.Humidity < c.HumidityCeiling && c.base > c.LowSpeed
+	if lower != c.lowered {
+		switch {
+		case lower:
+			log.Printf("Lowering fan speed from %d to %d in an expensive slot", c.base, c.LowSpeed)
+		case c.boosting:
+			log.Printf("Boosting fan speed in an expensive slot, humidity is %.0f%%", in.Humidity)
+		case in.Expensive:
+			log.Printf("Restoring fan speed %d, humidity is %.0f%%", c.base, in.Humidity)
+		default:
+			log.Printf("Restoring fan speed %d, expensive slot is over", c.base)
+		}
+		c.lowered = lower
+	}
+	switch {
+	case c.boosting:
+		return c.BoostSpeed
+	case lower:
+		return c.LowSpeed
+	}
+	return c.base
+}
+
+// Write the state to Path when it changed
+func (c *VentilationController) save() {
+	state := ventilationState{
+		Base:       c.base,
+		Lowered:    c.lowered,
+		Held:       c.held,
+		Boosting:   c.boosting,
+		BoostStart: c.boostStart,
+		BoostFrom:  c.boostFrom,
+	}
+	if c.Path == "" || state == c.saved {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.WriteFile(c.Path, data, 0666)
+	}
+	if err != nil {
+		log.Printf("error saving the ventilation state: %v", err)
+		return
+	}
+	c.saved = state
+}
+
+// Start or stop the humidity boost with the humidity of in
+func (c *VentilationController) updateBoost(in VentilationInput) {
+	recent := c.samples[:0]
+	for _, s := range c.samples {
+		if in.Now.Sub(s.t) <= c.BoostWindow {
+			recent = append(recent, s)
+		}
+	}
+	c.samples = append(recent, humiditySample{t: in.Now, humidity: in.Humidity})
+
+	wasBoosting := c.boosting
+	switch {
+	case c.boosting && !c.boost:
+		log.Printf("Humidity boost turned off, restoring fan speed %d", c.base)
+		c.boosting = false
+	case c.boosting && in.Humidity <= c.boostFrom+c.BoostSettle:
+		log.Printf("Humidity settled at %.0f%%, restoring fan speed %d", in.Humidity, c.base)
+		c.boosting = false
+	case c.boosting && in.Now.Sub(c.boostStart) >= c.BoostMax:
+		log.Printf("Humidity boost ran for %v, restoring fan speed %d", c.BoostMax, c.base)
+		c.boosting = false
+	case !c.boosting && c.boost:
+		lowest := in.Humidity
+		for _, s := range c.samples {
+			if s.humidity < lowest {
+				lowest = s.humidity
+			}
+		}
+		if in.Humidity-lowest >= c.BoostRise && c.base < c.BoostSpeed {
+			log.Printf("Humidity rose from %.0f%% to %.0f%%, boosting fan speed from %d to %d", lowest, in.Humidity, c.base, c.BoostSpeed)
+			c.boosting = true
+			c.boostStart = in.Now
+			c.boostFrom = lowest
+		}
+	}
+	// Only a new rise starts the next boost
+	if wasBoosting && !c.boosting {
+		c.samples = nil
+	}
+}
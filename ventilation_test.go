@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+func TestVentilationControllerManualSpeed(t *testing.T) {
+	start := time.Date(2026, 10, 16, 17, 0, 0, 0, priceLocation)
+	c := &VentilationController{LowSpeed: 101, HumidityCeiling: 70}
+	steps := []struct {
+		minute    int
+		expensive bool
+		speed     nilan.FanSpeed
+		want      nilan.FanSpeed
+	}{
+		{0, false, 102, 102},
+		{1, true, 102, 101},
+		{2, true, 101, 101},
+		// Set by hand in the expensive slot
+		{3, true, 103, 103},
+		{4, true, 103, 103},
+		{30, true, 103, 103},
+		// Lowered again in the next expensive slot
+		{60, false, 103, 103},
+		{120, true, 103, 101},
+		{180, false, 101, 103},
+	}
+	for _, s := range steps {
+		got := c.Step(VentilationInput{
+			Now:       start.Add(time.Duration(s.minute) * time.Minute),
+			Expensive: s.expensive,
+			Humidity:  50,
+			Speed:     s.speed,
+		})
+		if got != s.want {
+			t.Fatalf("minute %d: got fan speed %d, want %d", s.minute, got, s.want)
+		}
+	}
+}
+
+func TestVentilationControllerRestartWhileLowered(t *testing.T) {
+	start := time.Date(2026, 10, 16, 17, 0, 0, 0, priceLocation)
+	path := filepath.Join(t.TempDir(), "ventilation.json")
+	open := func() *VentilationController {
+		c, err := OpenVentilationController(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.LowSpeed, c.HumidityCeiling = 101, 70
+		return c
+	}
+
+	in := VentilationInput{Now: start, Expensive: true, Humidity: 50, Speed: 103}
+	if got := open().Step(in); got != 101 {
+		t.Fatalf("expensive slot: got fan speed %d, want 101", got)
+	}
+	// Restarted while lowered the speed from before is restored after the slot
+	c := open()
+	in = VentilationInput{Now: start.Add(time.Minute), Expensive: true, Humidity: 50, Speed: 101}
+	if got := c.Step(in); got != 101 {
+		t.Fatalf("expensive slot after a restart: got fan speed %d, want 101", got)
+	}
+	in = VentilationInput{Now: start.Add(time.Hour), Humidity: 50, Speed: 101}
+	if got := c.Step(in); got != 103 {
+		t.Fatalf("expensive slot over after a restart: got fan speed %d, want 103", got)
+	}
+}
+
+func TestVentilationControllerRestartWhileBoosting(t *testing.T) {
+	start := time.Date(2026, 10, 16, 7, 0, 0, 0, priceLocation)
+	path := filepath.Join(t.TempDir(), "ventilation.json")
+	open := func() *VentilationController {
+		c, err := OpenVentilationController(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.LowSpeed, c.HumidityCeiling, c.BoostSpeed = 101, 70, 104
+		c.BoostRise, c.BoostWindow, c.BoostSettle, c.BoostMax = 5, 10*time.Minute, 3, time.Hour
+		c.SetBoost(true)
+		return c
+	}
+
+	c := open()
+	c.Step(VentilationInput{Now: start, Humidity: 45, Speed: 102})
+	if got := c.Step(VentilationInput{Now: start.Add(2 * time.Minute), Humidity: 60, Speed: 102}); got != 104 {
+		t.Fatalf("humidity rise: got fan speed %d, want 104", got)
+	}
+	// Restarted while boosting the speed from before is restored when the humidity settles
+	c = open()
+	if got := c.Step(VentilationInput{Now: start.Add(5 * time.Minute), Humidity: 58, Speed: 104}); got != 104 {
+		t.Fatalf("boost after a restart: got fan speed %d, want 104", got)
+	}
+	if got := c.Step(VentilationInput{Now: start.Add(30 * time.Minute), Humidity: 47, Speed: 104}); got != 102 {
+		t.Fatalf("humidity settled after a restart: got fan speed %d, want 102", got)
+	}
+	// and the next rise boosts again
+	c.Step(VentilationInput{Now: start.Add(40 * time.Minute), Humidity: 47, Speed: 102})
+	if got := c.Step(VentilationInput{Now: start.Add(42 * time.Minute), Humidity: 60, Speed: 102}); got != 104 {
+		t.Fatalf("next humidity rise: got fan speed %d, want 104", got)
+	}
+}
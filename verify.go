@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// ErrNotConverged is returned when the machine does not take the settings sent to it
+var ErrNotConverged = errors.New("settings not applied")
+
+// VerifiedController reads the settings back after sending them until the machine has them. A
+// mismatch is sent again after a delay starting at MinDelay and doubling up to MaxDelay, at most
+// Retries times. A setting the machine does not take raises an alert until the same setting is
+// taken by a later send. Report is called after every SendSettings with an ErrNotConverged error
+// naming the settings with an alert, or nil when there are none.
+type VerifiedController struct {
+	Controller
+	Retries  int
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	Report   func(err error)
+
+	// Sends are one at a time so their read-backs do not see each other's settings
+	mu     sync.Mutex
+	alerts map[string]bool
+}
+
+// SendSettings and verify them, returning an ErrNotConverged error if the machine does not have
+// them after the retries
+func (v *VerifiedController) SendSettings(s nilan.Settings) error {
+	delay := v.MinDelay
+	var failed []string
+	var err error
+	for attempt := 0; attempt <= v.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Settings not applied, sending again in %v: %v", delay, err)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > v.MaxDelay {
+				delay = v.MaxDelay
+			}
+		}
+		if failed, err = v.send(s); err == nil {
+			v.alert(&s, nil)
+			return nil
+		}
+	}
+
+	err = fmt.Errorf("%w after %d tries: %v", ErrNotConverged, v.Retries+1, err)
+	log.Printf("ALERT: %v", err)
+	v.alert(&s, failed)
+	return err
+}
+
+// Send s once and read it back, returning the names of the settings not taken with the error.
+// The lock is held until the read-back but not while waiting to retry, so other sends are not
+// held up by the backoff.
+func (v *VerifiedController) send(s nilan.Settings) ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.Controller.SendSettings(s); err != nil {
+		return settingNames(&s), err
+	}
+	time.Sleep(v.MinDelay)
+	got, err := v.Controller.FetchSettings()
+	if err == nil && got == nil {
+		err = ErrIncompleteSettings
+	}
+	if err != nil {
+		return settingNames(&s), fmt.Errorf("reading back: %w", err)
+	}
+	if mismatch := settingsMismatch(&s, got); len(mismatch) > 0 {
+		return mismatch, fmt.Errorf("%s differ", strings.Join(mismatch, ", "))
+	}
+	return nil, nil
+}
+
+// Raise an alert for the settings of s named in failed and clear it for the others, and report
+// the settings with an alert
+func (v *VerifiedController) alert(s *nilan.Settings, failed []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.alerts == nil {
+		v.alerts = make(map[string]bool)
+	}
+	for _, name := range settingNames(s) {
+		delete(v.alerts, name)
+	}
+	for _, name := range failed {
+		v.alerts[name] = true
+	}
+	if v.Report == nil {
+		return
+	}
+	if len(v.alerts) == 0 {
+		v.Report(nil)
+		return
+	}
+	var names []string
+	for name := range v.alerts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	v.Report(fmt.Errorf("%w: %s", ErrNotConverged, strings.Join(names, ", ")))
+}
+
+// Return the names of the values set in s
+func settingNames(s *nilan.Settings) []string {
+	// Every value set differs from no value
+	return settingsMismatch(s, &nilan.Settings{})
+}
+
+// Return the names of the values set in want that got does not have
+func settingsMismatch(want, got *nilan.Settings) []string {
+	var names []string
+	for _, v := range []struct {
+		name    string
+		differs bool
+	}{
+		{"FanSpeed", differs(want.FanSpeed, got.FanSpeed)},
+		{"DesiredRoomTemperature", differs(want.DesiredRoomTemperature, got.DesiredRoomTemperature)},
+		{"DesiredDHWTemperature", differs(want.DesiredDHWTemperature, got.DesiredDHWTemperature)},
+		{"DHWProductionPaused", differs(want.DHWProductionPaused, got.DHWProductionPaused)},
+		{"DHWProductionPauseDuration", differs(want.DHWProductionPauseDuration, got.DHWProductionPauseDuration)},
+		{"CentralHeatingPaused", differs(want.CentralHeatingPaused, got.CentralHeatingPaused)},
+		{"CentralHeatingPauseDuration", differs(want.CentralHeatingPauseDuration, got.CentralHeatingPauseDuration)},
+		{"CentralHeatingIsOn", differs(want.CentralHeatingIsOn, got.CentralHeatingIsOn)},
+		{"VentilationMode", differs(want.VentilationMode, got.VentilationMode)},
+		{"VentilationOnPause", differs(want.VentilationOnPause, got.VentilationOnPause)},
+		{"SetpointSupplyTemperature", differs(want.SetpointSupplyTemperature, got.SetpointSupplyTemperature)},
+	} {
+		if v.differs {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+// Tells if want is set and got does not have its value
+func differs[T comparable](want, got *T) bool {
+	return want != nil && (got == nil || *want != *got)
+}
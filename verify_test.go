@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjuzeliunas/nilan"
+)
+
+// Controller that keeps the settings sent to it, except a fan speed above high
+type clampingController struct {
+	mu       sync.Mutex
+	settings nilan.Settings
+	sent     chan struct{}
+}
+
+func (c *clampingController) FetchReadings() (*nilan.Readings, error) { return &nilan.Readings{}, nil }
+
+func (c *clampingController) FetchSettings() (*nilan.Settings, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.settings
+	return &s, nil
+}
+
+func (c *clampingController) SendSettings(s nilan.Settings) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.FanSpeed != nil {
+		speed := *s.FanSpeed
+		if speed > nilan.FanSpeedHigh {
+			speed = nilan.FanSpeedHigh
+		}
+		c.settings.FanSpeed = &speed
+	}
+	if s.DesiredDHWTemperature != nil {
+		c.settings.DesiredDHWTemperature = s.DesiredDHWTemperature
+	}
+	select {
+	case c.sent <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestVerifiedControllerBackoffUnlocked(t *testing.T) {
+	c := &clampingController{sent: make(chan struct{}, 1)}
+	v := &VerifiedController{Controller: c, Retries: 3, MinDelay: 20 * time.Millisecond, MaxDelay: time.Second}
+
+	// The fan speed is never applied, so its send backs off three times
+	stuck := make(chan error)
+	go func() {
+		speed := nilan.FanSpeedVeryHigh
+		stuck <- v.SendSettings(nilan.Settings{FanSpeed: &speed})
+	}()
+	<-c.sent
+
+	// Another send goes through while the first one waits to retry
+	dhw := 500
+	if err := v.SendSettings(nilan.Settings{DesiredDHWTemperature: &dhw}); err != nil {
+		t.Fatalf("sending while another send backs off: %v", err)
+	}
+	select {
+	case err := <-stuck:
+		t.Fatalf("the other send waited for the backoff to end: %v", err)
+	default:
+	}
+	if err := <-stuck; !errors.Is(err, ErrNotConverged) {
+		t.Errorf("fan speed that is not applied: got %v, want %v", err, ErrNotConverged)
+	}
+}
+
+func TestVerifiedControllerAlertPerSetting(t *testing.T) {
+	var reports []error
+	c := &clampingController{}
+	v := &VerifiedController{Controller: c, Retries: 1, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	v.Report = func(err error) { reports = append(reports, err) }
+
+	veryHigh, high, dhw := nilan.FanSpeedVeryHigh, nilan.FanSpeedHigh, 500
+	sends := []struct {
+		settings nilan.Settings
+		// alert is the settings with an alert after the send
+		alert string
+	}{
+		{nilan.Settings{FanSpeed: &veryHigh}, "FanSpeed"},
+		// Another setting that is taken leaves the alert
+		{nilan.Settings{DesiredDHWTemperature: &dhw}, "FanSpeed"},
+		{nilan.Settings{FanSpeed: &veryHigh, DesiredDHWTemperature: &dhw}, "FanSpeed"},
+		// The same setting taken clears it
+		{nilan.Settings{FanSpeed: &high}, ""},
+	}
+	for i, s := range sends {
+		v.SendSettings(s.settings)
+		got := reports[len(reports)-1]
+		switch {
+		case s.alert == "" && got != nil:
+			t.Errorf("send %d: got alert %v, want none", i, got)
+		case s.alert != "" && (!errors.Is(got, ErrNotConverged) || !strings.HasSuffix(got.Error(), ": "+s.alert)):
+			t.Errorf("send %d: got alert %v, want one for %s", i, got, s.alert)
+		}
+	}
+}